@@ -20,7 +20,9 @@ type (
 
 	VarDecl struct {
 		Ident    *Ident
+		Type     Type // or nil, if inferred from X
 		X        Expr
+		Spelling string // spelling of ":=" in the source code
 		StartPos lexer.Pos
 		EndPos   lexer.Pos
 	}
@@ -39,6 +41,20 @@ type (
 		Node
 	}
 
+	// Array is a fixed-size array type, if Len is not
+	// nil, and a slice-like array type otherwise.
+	Array struct {
+		Len      *I64
+		Elem     Type
+		StartPos lexer.Pos
+	}
+
+	// BadType is a placeholder for a type containing syntax errors.
+	BadType struct {
+		StartPos lexer.Pos
+		EndPos   lexer.Pos
+	}
+
 	Func struct {
 		Params   []Type
 		Result   Type
@@ -50,19 +66,39 @@ type (
 		StartPos lexer.Pos
 		EndPos   lexer.Pos
 	}
+
+	Union struct {
+		Types     []Type
+		Spellings []string // spellings of the "|" in the source code
+	}
 )
 
+func (t *Array) Pos() lexer.Pos { return t.StartPos }
+func (t *Array) End() lexer.Pos { return t.Elem.End() }
+
+func (t *BadType) Pos() lexer.Pos { return t.StartPos }
+func (t *BadType) End() lexer.Pos { return t.EndPos }
+
 func (t *Func) Pos() lexer.Pos { return t.StartPos }
 func (t *Func) End() lexer.Pos { return t.Result.End() }
 
 func (t *Scalar) Pos() lexer.Pos { return t.StartPos }
 func (t *Scalar) End() lexer.Pos { return t.EndPos }
 
-func (*Func) node()   {}
-func (*Scalar) node() {}
+func (t *Union) Pos() lexer.Pos { return t.Types[0].Pos() }
+func (t *Union) End() lexer.Pos { return t.Types[len(t.Types)-1].End() }
+
+func (*Array) node()   {}
+func (*BadType) node() {}
+func (*Func) node()    {}
+func (*Scalar) node()  {}
+func (*Union) node()   {}
 
-func (*Func) typ()   {}
-func (*Scalar) typ() {}
+func (*Array) typ()   {}
+func (*BadType) typ() {}
+func (*Func) typ()    {}
+func (*Scalar) typ()  {}
+func (*Union) typ()   {}
 
 type (
 	Cmd interface {
@@ -77,8 +113,15 @@ type (
 	}
 
 	Assign struct {
-		Ident    *Ident
+		LHS      Expr // Ident or IndexExpr
 		X        Expr
+		Spelling string // spelling of "<-" in the source code
+		StartPos lexer.Pos
+		EndPos   lexer.Pos
+	}
+
+	// BadCmd is a placeholder for a cmd containing syntax errors.
+	BadCmd struct {
 		StartPos lexer.Pos
 		EndPos   lexer.Pos
 	}
@@ -99,6 +142,12 @@ type (
 		EndPos   lexer.Pos
 	}
 
+	// ExprCmd is a call expr, whose result is discarded.
+	ExprCmd struct {
+		X      Expr
+		EndPos lexer.Pos
+	}
+
 	For struct {
 		X        Expr
 		Block    *Block
@@ -125,6 +174,9 @@ func (c *Assert) End() lexer.Pos { return c.EndPos }
 func (c *Assign) Pos() lexer.Pos { return c.StartPos }
 func (c *Assign) End() lexer.Pos { return c.EndPos }
 
+func (c *BadCmd) Pos() lexer.Pos { return c.StartPos }
+func (c *BadCmd) End() lexer.Pos { return c.EndPos }
+
 func (c *Block) Pos() lexer.Pos { return c.StartPos }
 func (c *Block) End() lexer.Pos { return c.EndPos }
 
@@ -134,6 +186,9 @@ func (c *Break) End() lexer.Pos { return c.EndPos }
 func (c *Continue) Pos() lexer.Pos { return c.StartPos }
 func (c *Continue) End() lexer.Pos { return c.EndPos }
 
+func (c *ExprCmd) Pos() lexer.Pos { return c.X.Pos() }
+func (c *ExprCmd) End() lexer.Pos { return c.EndPos }
+
 func (c *For) Pos() lexer.Pos { return c.StartPos }
 func (c *For) End() lexer.Pos { return c.Block.End() }
 
@@ -150,18 +205,22 @@ func (c *Return) End() lexer.Pos { return c.EndPos }
 
 func (*Assert) node()   {}
 func (*Assign) node()   {}
+func (*BadCmd) node()   {}
 func (*Block) node()    {}
 func (*Break) node()    {}
 func (*Continue) node() {}
+func (*ExprCmd) node()  {}
 func (*For) node()      {}
 func (*If) node()       {}
 func (*Return) node()   {}
 
 func (*Assert) cmd()   {}
 func (*Assign) cmd()   {}
+func (*BadCmd) cmd()   {}
 func (*Block) cmd()    {}
 func (*Break) cmd()    {}
 func (*Continue) cmd() {}
+func (*ExprCmd) cmd()  {}
 func (*For) cmd()      {}
 func (*If) cmd()       {}
 func (*Return) cmd()   {}
@@ -173,10 +232,23 @@ type (
 		Node
 	}
 
+	// BadExpr is a placeholder for an expr containing syntax errors.
+	BadExpr struct {
+		StartPos lexer.Pos
+		EndPos   lexer.Pos
+	}
+
 	BinaryExpr struct {
-		LHS Expr
-		Op  lexer.Tok
-		RHS Expr
+		LHS      Expr
+		Op       lexer.Tok
+		RHS      Expr
+		Spelling string // spelling of Op in the source code
+	}
+
+	CallExpr struct {
+		Func   Expr
+		Args   []Expr
+		EndPos lexer.Pos
 	}
 
 	Ident struct {
@@ -184,6 +256,18 @@ type (
 		StartPos lexer.Pos
 	}
 
+	IndexExpr struct {
+		X      Expr
+		Index  Expr
+		EndPos lexer.Pos
+	}
+
+	// IsExpr tests, whether the value of X is of the given type.
+	IsExpr struct {
+		X    Expr
+		Type Type
+	}
+
 	ParenExpr struct {
 		X        Expr
 		StartPos lexer.Pos
@@ -193,29 +277,50 @@ type (
 	UnaryExpr struct {
 		Op       lexer.Tok
 		X        Expr
+		Spelling string // spelling of Op in the source code
 		StartPos lexer.Pos
 	}
 )
 
+func (x *BadExpr) Pos() lexer.Pos { return x.StartPos }
+func (x *BadExpr) End() lexer.Pos { return x.EndPos }
+
 func (x *BinaryExpr) Pos() lexer.Pos { return x.LHS.Pos() }
 func (x *BinaryExpr) End() lexer.Pos { return x.RHS.End() }
 
+func (x *CallExpr) Pos() lexer.Pos { return x.Func.Pos() }
+func (x *CallExpr) End() lexer.Pos { return x.EndPos }
+
 func (x *Ident) Pos() lexer.Pos { return x.StartPos }
 func (x *Ident) End() lexer.Pos { return x.StartPos.Shift(len(x.Name)) }
 
+func (x *IndexExpr) Pos() lexer.Pos { return x.X.Pos() }
+func (x *IndexExpr) End() lexer.Pos { return x.EndPos }
+
+func (x *IsExpr) Pos() lexer.Pos { return x.X.Pos() }
+func (x *IsExpr) End() lexer.Pos { return x.Type.End() }
+
 func (x *ParenExpr) Pos() lexer.Pos { return x.StartPos }
 func (x *ParenExpr) End() lexer.Pos { return x.EndPos }
 
 func (x *UnaryExpr) Pos() lexer.Pos { return x.StartPos }
 func (x *UnaryExpr) End() lexer.Pos { return x.X.End() }
 
+func (*BadExpr) node()    {}
 func (*BinaryExpr) node() {}
+func (*CallExpr) node()   {}
 func (*Ident) node()      {}
+func (*IndexExpr) node()  {}
+func (*IsExpr) node()     {}
 func (*ParenExpr) node()  {}
 func (*UnaryExpr) node()  {}
 
+func (*BadExpr) expr()    {}
 func (*BinaryExpr) expr() {}
+func (*CallExpr) expr()   {}
 func (*Ident) expr()      {}
+func (*IndexExpr) expr()  {}
+func (*IsExpr) expr()     {}
 func (*ParenExpr) expr()  {}
 func (*UnaryExpr) expr()  {}
 
@@ -225,6 +330,12 @@ type (
 		Expr
 	}
 
+	ArrayLit struct {
+		Type   *Array
+		Elems  []Expr
+		EndPos lexer.Pos
+	}
+
 	Bool struct {
 		Val      string
 		StartPos lexer.Pos
@@ -257,6 +368,9 @@ type (
 	}
 )
 
+func (l *ArrayLit) Pos() lexer.Pos { return l.Type.Pos() }
+func (l *ArrayLit) End() lexer.Pos { return l.EndPos }
+
 func (l *Bool) Pos() lexer.Pos { return l.StartPos }
 func (l *Bool) End() lexer.Pos { return l.EndPos }
 
@@ -272,23 +386,26 @@ func (l *I64) End() lexer.Pos { return l.EndPos }
 func (l *String) Pos() lexer.Pos { return l.StartPos }
 func (l *String) End() lexer.Pos { return l.EndPos }
 
-func (*Bool) node()    {}
-func (*F64) node()     {}
-func (*FuncLit) node() {}
-func (*I64) node()     {}
-func (*String) node()  {}
-
-func (*Bool) expr()    {}
-func (*F64) expr()     {}
-func (*FuncLit) expr() {}
-func (*I64) expr()     {}
-func (*String) expr()  {}
-
-func (*Bool) lit()    {}
-func (*F64) lit()     {}
-func (*FuncLit) lit() {}
-func (*I64) lit()     {}
-func (*String) lit()  {}
+func (*ArrayLit) node() {}
+func (*Bool) node()     {}
+func (*F64) node()      {}
+func (*FuncLit) node()  {}
+func (*I64) node()      {}
+func (*String) node()   {}
+
+func (*ArrayLit) expr() {}
+func (*Bool) expr()     {}
+func (*F64) expr()      {}
+func (*FuncLit) expr()  {}
+func (*I64) expr()      {}
+func (*String) expr()   {}
+
+func (*ArrayLit) lit() {}
+func (*Bool) lit()     {}
+func (*F64) lit()      {}
+func (*FuncLit) lit()  {}
+func (*I64) lit()      {}
+func (*String) lit()   {}
 
 type Field struct {
 	Ident *Ident
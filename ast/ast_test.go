@@ -7,15 +7,22 @@ package ast_test
 import "davidrjenni.io/lang/ast"
 
 var (
+	_ ast.Node = &ast.Array{}
+	_ ast.Node = &ast.ArrayLit{}
 	_ ast.Node = &ast.Assert{}
 	_ ast.Node = &ast.Assign{}
+	_ ast.Node = &ast.BadCmd{}
+	_ ast.Node = &ast.BadExpr{}
+	_ ast.Node = &ast.BadType{}
 	_ ast.Node = &ast.Block{}
 	_ ast.Node = &ast.BinaryExpr{}
 	_ ast.Node = &ast.Bool{}
 	_ ast.Node = &ast.Break{}
+	_ ast.Node = &ast.CallExpr{}
 	_ ast.Node = &ast.Comment{}
 	_ ast.Node = &ast.Continue{}
 	_ ast.Node = &ast.Else{}
+	_ ast.Node = &ast.ExprCmd{}
 	_ ast.Node = &ast.F64{}
 	_ ast.Node = &ast.Field{}
 	_ ast.Node = &ast.For{}
@@ -24,31 +31,44 @@ var (
 	_ ast.Node = &ast.I64{}
 	_ ast.Node = &ast.Ident{}
 	_ ast.Node = &ast.If{}
+	_ ast.Node = &ast.IndexExpr{}
+	_ ast.Node = &ast.IsExpr{}
 	_ ast.Node = &ast.ParenExpr{}
 	_ ast.Node = &ast.Return{}
 	_ ast.Node = &ast.Scalar{}
 	_ ast.Node = &ast.String{}
 	_ ast.Node = &ast.UnaryExpr{}
+	_ ast.Node = &ast.Union{}
 	_ ast.Node = &ast.VarDecl{}
 
 	_ ast.Decl = &ast.VarDecl{}
 
+	_ ast.Type = &ast.Array{}
+	_ ast.Type = &ast.BadType{}
 	_ ast.Type = &ast.Func{}
 	_ ast.Type = &ast.Scalar{}
+	_ ast.Type = &ast.Union{}
 
 	_ ast.Cmd = &ast.Assert{}
 	_ ast.Cmd = &ast.Assign{}
+	_ ast.Cmd = &ast.BadCmd{}
 	_ ast.Cmd = &ast.Block{}
 	_ ast.Cmd = &ast.Break{}
 	_ ast.Cmd = &ast.Continue{}
+	_ ast.Cmd = &ast.ExprCmd{}
 	_ ast.Cmd = &ast.For{}
 	_ ast.Cmd = &ast.If{}
 	_ ast.Cmd = &ast.Return{}
 	_ ast.Cmd = &ast.VarDecl{}
 
+	_ ast.Expr = &ast.BadExpr{}
 	_ ast.Expr = &ast.BinaryExpr{}
+	_ ast.Expr = &ast.CallExpr{}
+	_ ast.Expr = &ast.IndexExpr{}
+	_ ast.Expr = &ast.IsExpr{}
 	_ ast.Expr = &ast.ParenExpr{}
 	_ ast.Expr = &ast.UnaryExpr{}
+	_ ast.Expr = &ast.ArrayLit{}
 	_ ast.Expr = &ast.Bool{}
 	_ ast.Expr = &ast.F64{}
 	_ ast.Expr = &ast.FuncLit{}
@@ -56,6 +76,7 @@ var (
 	_ ast.Expr = &ast.Ident{}
 	_ ast.Expr = &ast.String{}
 
+	_ ast.Lit = &ast.ArrayLit{}
 	_ ast.Lit = &ast.Bool{}
 	_ ast.Lit = &ast.F64{}
 	_ ast.Lit = &ast.FuncLit{}
@@ -43,6 +43,19 @@ func (d *dumper) dumpTypes(ts []Type) {
 
 func (d *dumper) dumpType(t Type) {
 	switch t := t.(type) {
+	case *Array:
+		d.enter("Array(")
+		d.dumpPos(t)
+		if t.Len != nil {
+			d.print("Len: ")
+			d.dumpLit(t.Len)
+			d.println()
+		}
+		d.print("Elem: ")
+		d.dumpType(t.Elem)
+		d.exit(")")
+	case *BadType:
+		d.printf("BadType(Pos: %s, End: %s)", t.Pos(), t.End())
 	case *Func:
 		d.enter("Func(")
 		d.dumpPos(t)
@@ -58,6 +71,13 @@ func (d *dumper) dumpType(t Type) {
 		d.dumpPos(t)
 		d.printf("Name: %s", t.Name)
 		d.exit(")")
+	case *Union:
+		d.enter("Union(")
+		d.dumpPos(t)
+		d.enter("Types: (")
+		d.dumpTypes(t.Types)
+		d.exit(")")
+		d.exit(")")
 	default:
 		panic(fmt.Sprintf("unexpected type %T", t))
 	}
@@ -74,12 +94,14 @@ func (d *dumper) dumpCmd(cmd Cmd) {
 	case *Assign:
 		d.enter("Assign(")
 		d.dumpPos(cmd)
-		d.print("Ident: ")
-		d.dumpExpr(cmd.Ident)
+		d.print("LHS: ")
+		d.dumpExpr(cmd.LHS)
 		d.println()
 		d.print("X: ")
 		d.dumpExpr(cmd.X)
 		d.exit(")")
+	case *BadCmd:
+		d.printf("BadCmd(Pos: %s, End: %s)", cmd.Pos(), cmd.End())
 	case *Block:
 		d.enter("Block(")
 		d.dumpPos(cmd)
@@ -93,6 +115,12 @@ func (d *dumper) dumpCmd(cmd Cmd) {
 		d.printf("Break(Pos: %s, End: %s)", cmd.Pos(), cmd.End())
 	case *Continue:
 		d.printf("Continue(Pos: %s, End: %s)", cmd.Pos(), cmd.End())
+	case *ExprCmd:
+		d.enter("ExprCmd(")
+		d.dumpPos(cmd)
+		d.print("X: ")
+		d.dumpExpr(cmd.X)
+		d.exit(")")
 	case *For:
 		d.enter("For(")
 		d.dumpPos(cmd)
@@ -132,6 +160,11 @@ func (d *dumper) dumpCmd(cmd Cmd) {
 		d.print("Ident: ")
 		d.dumpExpr(cmd.Ident)
 		d.println()
+		if cmd.Type != nil {
+			d.print("Type: ")
+			d.dumpType(cmd.Type)
+			d.println()
+		}
 		d.print("X: ")
 		d.dumpExpr(cmd.X)
 		d.exit(")")
@@ -142,6 +175,8 @@ func (d *dumper) dumpCmd(cmd Cmd) {
 
 func (d *dumper) dumpExpr(x Expr) {
 	switch x := x.(type) {
+	case *BadExpr:
+		d.printf("BadExpr(Pos: %s, End: %s)", x.Pos(), x.End())
 	case *BinaryExpr:
 		d.enter("BinaryExpr(")
 		d.dumpPos(x)
@@ -153,8 +188,36 @@ func (d *dumper) dumpExpr(x Expr) {
 		d.print("RHS: ")
 		d.dump(x.RHS)
 		d.exit(")")
+	case *CallExpr:
+		d.enter("CallExpr(")
+		d.dumpPos(x)
+		d.print("Func: ")
+		d.dump(x.Func)
+		d.println()
+		d.enter("Args: (")
+		d.dumpExprs(x.Args)
+		d.exit(")")
+		d.exit(")")
 	case *Ident:
 		d.printf("Ident(Name: %q, Pos: %s, End: %s)", x.Name, x.Pos(), x.End())
+	case *IndexExpr:
+		d.enter("IndexExpr(")
+		d.dumpPos(x)
+		d.print("X: ")
+		d.dump(x.X)
+		d.println()
+		d.print("Index: ")
+		d.dump(x.Index)
+		d.exit(")")
+	case *IsExpr:
+		d.enter("IsExpr(")
+		d.dumpPos(x)
+		d.print("X: ")
+		d.dump(x.X)
+		d.println()
+		d.print("Type: ")
+		d.dumpType(x.Type)
+		d.exit(")")
 	case Lit:
 		d.dumpLit(x)
 	case *ParenExpr:
@@ -176,8 +239,26 @@ func (d *dumper) dumpExpr(x Expr) {
 	}
 }
 
+func (d *dumper) dumpExprs(xs []Expr) {
+	for i, x := range xs {
+		d.printf("%d: ", i)
+		d.dumpExpr(x)
+		d.println()
+	}
+}
+
 func (d *dumper) dumpLit(l Lit) {
 	switch l := l.(type) {
+	case *ArrayLit:
+		d.enter("ArrayLit(")
+		d.dumpPos(l)
+		d.print("Type: ")
+		d.dumpType(l.Type)
+		d.println()
+		d.enter("Elems: (")
+		d.dumpExprs(l.Elems)
+		d.exit(")")
+		d.exit(")")
 	case *Bool:
 		d.printf("Bool(Val: %v, Pos: %s, End: %s)", l.Val, l.Pos(), l.End())
 	case *F64:
@@ -0,0 +1,403 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ast // import "davidrjenni.io/lang/ast"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"davidrjenni.io/lang/lexer"
+)
+
+// Notation controls the spelling of the operators,
+// which have an ASCII and a Unicode notation.
+type Notation int
+
+const (
+	Original Notation = iota // spelling of the source code
+	ASCII                    // ASCII spelling, e.g. "<=" and ":="
+	Unicode                  // Unicode spelling, e.g. "≤" and "≔"
+)
+
+// Config controls the output of Fprint.
+type Config struct {
+	Notation Notation
+}
+
+// Fprint prints the block in the canonical layout, using
+// the original notation of the operators. The comments are
+// printed before the cmds following them or at the end of
+// the lines of the cmds preceding them. The comments within
+// a cmd end the line before the expr or the type following
+// them, which is continued indented. Parsing the output
+// yields the same block, apart from the positions.
+func Fprint(out io.Writer, b *Block, comments []*Comment) error {
+	var c Config
+	return c.Fprint(out, b, comments)
+}
+
+// Fprint prints the block like Fprint, using the notation
+// of the configuration.
+func (c *Config) Fprint(out io.Writer, b *Block, comments []*Comment) error {
+	p := &printer{notation: c.Notation, comments: comments}
+	p.leadingComments(b.Pos())
+	if p.buf.Len() > 0 {
+		p.newline(b.Pos())
+	}
+	p.block(b)
+	p.trailingComments(b.End(), lexer.Pos{})
+	for _, c := range p.comments {
+		p.newline(c.Pos())
+		p.comment(c)
+	}
+	p.buf.WriteByte('\n')
+	_, err := out.Write(p.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	notation Notation
+	indent   int
+	comments []*Comment // comments, which are not printed yet
+	line     int        // line of the last printed node, or 0
+}
+
+// newline starts a new line for the node at pos. A blank line
+// in the source code before the node is preserved.
+func (p *printer) newline(pos lexer.Pos) {
+	if p.buf.Len() > 0 {
+		if p.line > 0 && pos.Line() > p.line+1 {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+	}
+	p.buf.WriteString(strings.Repeat("\t", p.indent))
+}
+
+// leadingComments prints the comments before pos on separate lines.
+func (p *printer) leadingComments(pos lexer.Pos) {
+	for len(p.comments) > 0 && p.comments[0].Pos().Offset() < pos.Offset() {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.newline(c.Pos())
+		p.comment(c)
+	}
+}
+
+// trailingComments prints the comments before end or on the line of
+// end, but before next, if it is valid. The first one is printed at
+// the end of the current line.
+func (p *printer) trailingComments(end, next lexer.Pos) {
+	p.line = end.Line()
+	for i := 0; len(p.comments) > 0; i++ {
+		c := p.comments[0]
+		if c.Pos().Offset() >= end.Offset() && c.Pos().Line() != end.Line() {
+			break
+		}
+		if next.IsValid() && c.Pos().Offset() >= next.Offset() {
+			break
+		}
+		p.comments = p.comments[1:]
+		if i == 0 {
+			p.buf.WriteByte(' ')
+		} else {
+			p.newline(c.Pos())
+		}
+		p.comment(c)
+	}
+	// The comments may precede the end on its line.
+	if p.line < end.Line() {
+		p.line = end.Line()
+	}
+}
+
+// inlineComments prints the comments before pos at the end of the
+// current line and continues the line indented on the next one.
+func (p *printer) inlineComments(pos lexer.Pos) {
+	if len(p.comments) == 0 || p.comments[0].Pos().Offset() >= pos.Offset() {
+		return
+	}
+	p.buf.Truncate(len(bytes.TrimRight(p.buf.Bytes(), " ")))
+	indent := strings.Repeat("\t", p.indent+1)
+	for i := 0; len(p.comments) > 0 && p.comments[0].Pos().Offset() < pos.Offset(); i++ {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if i == 0 {
+			p.buf.WriteByte(' ')
+		} else {
+			p.buf.WriteString("\n" + indent)
+		}
+		p.comment(c)
+	}
+	p.buf.WriteString("\n" + indent)
+}
+
+func (p *printer) comment(c *Comment) {
+	p.buf.WriteString(strings.TrimRight(c.Text, " \t\r"))
+	p.line = c.Pos().Line()
+}
+
+func (p *printer) block(b *Block) {
+	if len(b.Cmds) == 0 && (len(p.comments) == 0 || p.comments[0].Pos().Offset() > b.End().Offset()) {
+		p.print("{}")
+		return
+	}
+	p.print("{")
+	p.indent++
+	p.line = 0
+	for i, cmd := range b.Cmds {
+		p.leadingComments(cmd.Pos())
+		p.newline(cmd.Pos())
+		p.cmd(cmd)
+		next := b.End()
+		if i+1 < len(b.Cmds) {
+			next = b.Cmds[i+1].Pos()
+		}
+		p.trailingComments(cmd.End(), next)
+	}
+	p.leadingComments(b.End())
+	p.indent--
+	p.line = 0
+	p.newline(b.End())
+	p.print("}")
+}
+
+func (p *printer) cmd(cmd Cmd) {
+	switch cmd := cmd.(type) {
+	case *Assert:
+		p.print("assert ")
+		p.expr(cmd.X)
+		p.print(";")
+	case *Assign:
+		p.print("set ")
+		p.expr(cmd.LHS)
+		p.print(" ", p.op(lexer.Assign, cmd.Spelling), " ")
+		p.expr(cmd.X)
+		p.print(";")
+	case *Block:
+		p.block(cmd)
+	case *Break:
+		p.print("break;")
+	case *Continue:
+		p.print("continue;")
+	case *ExprCmd:
+		p.expr(cmd.X)
+		p.print(";")
+	case *For:
+		p.print("for ")
+		p.expr(cmd.X)
+		p.print(" ")
+		p.block(cmd.Block)
+	case *If:
+		p.print("if ")
+		p.expr(cmd.X)
+		p.print(" ")
+		p.block(cmd.Block)
+		if cmd.Else != nil {
+			p.print(" else ")
+			p.cmd(cmd.Else.Cmd)
+		}
+	case *Return:
+		p.print("return ")
+		p.expr(cmd.X)
+		p.print(";")
+	case *VarDecl:
+		p.print("let ")
+		p.expr(cmd.Ident)
+		if cmd.Type != nil {
+			p.print(" ")
+			p.typ(cmd.Type)
+		}
+		p.print(" ", p.op(lexer.Define, cmd.Spelling), " ")
+		p.expr(cmd.X)
+		p.print(";")
+	default:
+		panic(fmt.Sprintf("unexpected type %T", cmd))
+	}
+}
+
+func (p *printer) expr(x Expr) {
+	p.inlineComments(x.Pos())
+	switch x := x.(type) {
+	case *ArrayLit:
+		p.typ(x.Type)
+		p.print("{")
+		p.exprs(x.Elems)
+		p.inlineComments(x.End())
+		p.print("}")
+	case *BinaryExpr:
+		p.expr(x.LHS)
+		p.print(" ", p.op(x.Op, x.Spelling), " ")
+		p.expr(x.RHS)
+	case *Bool:
+		p.print(x.Val)
+	case *CallExpr:
+		p.expr(x.Func)
+		p.print("(")
+		p.exprs(x.Args)
+		p.inlineComments(x.End())
+		p.print(")")
+	case *F64:
+		p.print(x.Val)
+	case *FuncLit:
+		p.print("func(")
+		for i, f := range x.Params {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.expr(f.Ident)
+			p.print(" ")
+			p.typ(f.Type)
+		}
+		p.print(") ")
+		p.typ(x.Result)
+		p.print(" ")
+		p.block(x.Block)
+	case *I64:
+		p.print(x.Val)
+	case *Ident:
+		p.print(x.Name)
+	case *IndexExpr:
+		p.expr(x.X)
+		p.print("[")
+		p.expr(x.Index)
+		p.inlineComments(x.End())
+		p.print("]")
+	case *IsExpr:
+		p.expr(x.X)
+		p.print(" is ")
+		p.baseType(x.Type)
+	case *ParenExpr:
+		p.print("(")
+		p.expr(x.X)
+		p.inlineComments(x.End())
+		p.print(")")
+	case *String:
+		p.print(x.Val)
+	case *UnaryExpr:
+		p.print(p.op(x.Op, x.Spelling))
+		p.expr(x.X)
+	default:
+		panic(fmt.Sprintf("unexpected type %T", x))
+	}
+}
+
+func (p *printer) exprs(xs []Expr) {
+	for i, x := range xs {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) typ(t Type) {
+	p.inlineComments(t.Pos())
+	switch t := t.(type) {
+	case *Array:
+		p.print("[")
+		if t.Len != nil {
+			p.print(t.Len.Val)
+		}
+		p.print("]")
+		p.baseType(t.Elem)
+	case *Func:
+		p.print("func(")
+		for i, param := range t.Params {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.typ(param)
+		}
+		p.print(") ")
+		p.typ(t.Result)
+	case *Scalar:
+		p.print(t.Name)
+	case *Union:
+		for i, u := range t.Types {
+			if i > 0 {
+				var spelling string
+				if i-1 < len(t.Spellings) {
+					spelling = t.Spellings[i-1]
+				}
+				p.print(" ", p.op(lexer.Or, spelling), " ")
+			}
+			// The result type of a func would
+			// contain the following types.
+			if endsWithFunc(u) && i < len(t.Types)-1 {
+				p.print("(")
+				p.typ(u)
+				p.print(")")
+				continue
+			}
+			p.baseType(u)
+		}
+	default:
+		panic(fmt.Sprintf("unexpected type %T", t))
+	}
+}
+
+// baseType prints the type, parenthesized if it is a union.
+func (p *printer) baseType(t Type) {
+	if _, ok := t.(*Union); ok {
+		p.print("(")
+		p.typ(t)
+		p.print(")")
+		return
+	}
+	p.typ(t)
+}
+
+// endsWithFunc reports whether the type ends with a func type.
+func endsWithFunc(t Type) bool {
+	switch t := t.(type) {
+	case *Array:
+		return endsWithFunc(t.Elem)
+	case *Func:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *printer) print(args ...string) {
+	for _, s := range args {
+		p.buf.WriteString(s)
+	}
+}
+
+// op returns the spelling of the operator in the notation of
+// the printer. The original notation falls back to ASCII, if
+// the spelling is unknown.
+func (p *printer) op(tok lexer.Tok, spelling string) string {
+	switch {
+	case p.notation == Original && spelling != "":
+		return spelling
+	case p.notation == Unicode:
+		return tok.String()
+	}
+	if s, ok := asciiOps[tok]; ok {
+		return s
+	}
+	return tok.String()
+}
+
+var asciiOps = map[lexer.Tok]string{
+	lexer.Define:    ":=",
+	lexer.Assign:    "<-",
+	lexer.Multiply:  "*",
+	lexer.Divide:    "/",
+	lexer.And:       "&",
+	lexer.Or:        "|",
+	lexer.Implies:   "=>",
+	lexer.LessEq:    "<=",
+	lexer.NotEqual:  "#",
+	lexer.GreaterEq: ">=",
+	lexer.In:        "in",
+	lexer.Not:       "~",
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ast_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/parser"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var notations = [...]struct {
+	notation ast.Notation
+	golden   string
+}{
+	{notation: ast.Original, golden: "input.golden"},
+	{notation: ast.ASCII, golden: "input.ascii.golden"},
+	{notation: ast.Unicode, golden: "input.unicode.golden"},
+}
+
+func TestFprint(t *testing.T) {
+	filename := filepath.Join("test-fixtures", "input.l")
+	for _, n := range notations {
+		actual := format(t, &ast.Config{Notation: n.notation}, filename)
+		compareGolden(t, filepath.Join("test-fixtures", n.golden), actual)
+	}
+}
+
+func TestFprintComments(t *testing.T) {
+	// The comments within the params, the array
+	// literals and the wrapped exprs stay in place.
+	actual := format(t, &ast.Config{}, filepath.Join("test-fixtures", "comments.l"))
+	compareGolden(t, filepath.Join("test-fixtures", "comments.golden"), actual)
+}
+
+func TestFprintReparse(t *testing.T) {
+	filenames := []string{
+		filepath.Join("test-fixtures", "input.l"),
+		filepath.Join("test-fixtures", "comments.l"),
+		filepath.Join("..", "parser", "test-fixtures", "input.l"),
+		filepath.Join("..", "types", "test-fixtures", "input.l"),
+		filepath.Join("..", "ir", "test-fixtures", "input.l"),
+		filepath.Join("..", "compiler", "test-fixtures", "input.l"),
+		filepath.Join("..", "interp", "test-fixtures", "input.l"),
+	}
+	for _, filename := range filenames {
+		for _, n := range notations {
+			orig, _, err := parser.ParseFile(filename)
+			if err != nil {
+				t.Fatalf("cannot parse file: %v", err)
+			}
+
+			cfg := &ast.Config{Notation: n.notation}
+			formatted := format(t, cfg, filename)
+			b, comments, err := parser.Parse(bytes.NewReader(formatted), filename)
+			if err != nil {
+				t.Fatalf("%s: cannot parse formatted source: %v", filename, err)
+			}
+
+			var again bytes.Buffer
+			if err := cfg.Fprint(&again, b, comments); err != nil {
+				t.Fatalf("cannot print: %v", err)
+			}
+			if !bytes.Equal(again.Bytes(), formatted) {
+				t.Errorf("%s: formatting is not idempotent:\n%s", filename, again.String())
+			}
+
+			// Converting the notation changes only the spellings.
+			spellings := n.notation != ast.Original
+			clearPos(reflect.ValueOf(orig), spellings)
+			clearPos(reflect.ValueOf(b), spellings)
+			if !reflect.DeepEqual(orig, b) {
+				t.Errorf("%s: formatted source yields a different tree", filename)
+			}
+		}
+	}
+}
+
+func format(t *testing.T, cfg *ast.Config, filename string) []byte {
+	b, comments, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, b, comments); err != nil {
+		t.Fatalf("cannot print: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func compareGolden(t *testing.T, golden string, actual []byte) {
+	if *update {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("cannot update golden file: %v", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("cannot read golden file: %v", err)
+	}
+
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("%s: expected\n%s\ngot\n%s\n", golden, string(expected), string(actual))
+	}
+}
+
+// clearPos sets all positions reachable from v to the zero
+// value and the spellings as well, if spellings is set.
+func clearPos(v reflect.Value, spellings bool) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			clearPos(v.Elem(), spellings)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			clearPos(v.Index(i), spellings)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(lexer.Pos{}) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if spellings && (f.Name == "Spelling" || f.Name == "Spellings") {
+				v.Field(i).Set(reflect.Zero(f.Type))
+				continue
+			}
+			clearPos(v.Field(i), spellings)
+		}
+	}
+}
@@ -5,15 +5,24 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 
+	"davidrjenni.io/lang/ast"
 	"davidrjenni.io/lang/compiler"
+	"davidrjenni.io/lang/internal/errors"
+	"davidrjenni.io/lang/interp"
 	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/lsp"
 	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/repl"
 	"davidrjenni.io/lang/types"
+	"davidrjenni.io/lang/vm"
 )
 
 func main() {
@@ -26,48 +35,117 @@ func main() {
 	case "help", "":
 		printHelp()
 
-	case "run":
-		if len(os.Args) < 3 {
-			die("lang: no lang files listed")
+	case "build":
+		var filename string
+		target := "native"
+		for _, a := range os.Args[2:] {
+			switch {
+			case strings.HasPrefix(a, "-target="):
+				target = strings.TrimPrefix(a, "-target=")
+			case a == "-json":
+				jsonOutput = true
+			default:
+				filename = a
+			}
+		}
+		if filename == "" {
+			die("lang: no lang files listed\n")
 		}
 
-		filename := os.Args[2]
-		b, _, err := parser.ParseFile(filename)
-		if err != nil {
-			die("%v\n", err)
+		b, info := check(filename)
+		frames := translate(b, info)
+		out := strings.TrimSuffix(filename, ".l")
+		switch target {
+		case "native":
+			if out == filename {
+				out += ".out"
+			}
+			asm := compileAsm(filename, frames)
+			defer os.Remove(asm)
+			link(asm, out)
+		case "vm":
+			f, err := os.Create(out + ".lbc")
+			if err != nil {
+				die("%v\n", err)
+			}
+			if _, err := vm.Compile(filename, frames).WriteTo(f); err != nil {
+				die("%v\n", err)
+			}
+			if err := f.Close(); err != nil {
+				die("%v\n", err)
+			}
+		default:
+			die("lang: unknown target %s\n", target)
 		}
 
-		info, err := types.Check(b)
+	case "exec":
+		if len(os.Args) < 3 {
+			die("lang: no bytecode file listed\n")
+		}
+		f, err := os.Open(os.Args[2])
 		if err != nil {
 			die("%v\n", err)
 		}
-
-		asmFile, err := ioutil.TempFile("", "lang_build*.s")
+		p, err := vm.Read(f)
+		f.Close()
 		if err != nil {
-			die("%v\n", err)
+			die("lang: %s: %v\n", os.Args[2], err)
+		}
+		if err := vm.Run(os.Stdout, p); err != nil {
+			dieRun(err)
 		}
-		defer os.Remove(asmFile.Name())
 
-		frames := ir.Translate(b, info, ir.Loads)
-		for _, f := range frames {
-			compiler.Compile(asmFile, filename, f)
+	case "lsp":
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			die("lang: %v\n", err)
 		}
 
-		if err := asmFile.Close(); err != nil {
+	case "repl":
+		if err := repl.Run(os.Stdin, os.Stdout); err != nil {
 			die("%v\n", err)
 		}
 
-		for _, a := range os.Args {
-			if a == "-S" {
-				sz, err := os.ReadFile(asmFile.Name())
-				if err != nil {
-					die("%v\n", err)
-				}
-				if err = ioutil.WriteFile(filename+".S", sz, 0644); err != nil {
-					die("%v\n", err)
-				}
-				return
+	case "run":
+		var filename string
+		var asmOnly, useInterp bool
+		for _, a := range os.Args[2:] {
+			switch a {
+			case "-S":
+				asmOnly = true
+			case "-interp":
+				useInterp = true
+			case "-json":
+				jsonOutput = true
+			default:
+				filename = a
+			}
+		}
+		if filename == "" {
+			die("lang: no lang files listed")
+		}
+
+		b, info := check(filename)
+		if useInterp {
+			printFoldWarnings(b, info)
+			if err := interp.Run(os.Stdout, b, info); err != nil {
+				dieRun(err)
+			}
+			return
+		}
+
+		frames := translate(b, info)
+		asm := compileAsm(filename, frames)
+		defer os.Remove(asm)
+
+		if asmOnly {
+			sz, err := os.ReadFile(asm)
+			if err != nil {
+				die("%v\n", err)
+			}
+			if err = ioutil.WriteFile(filename+".S", sz, 0644); err != nil {
+				die("%v\n", err)
 			}
+			return
 		}
 
 		exeFile, err := ioutil.TempFile("", "lang_build*.out")
@@ -78,26 +156,234 @@ func main() {
 			die("%v\n", err)
 		}
 		defer os.Remove(exeFile.Name())
-
-		asm := exec.Command("gcc", "-no-pie", asmFile.Name(), "-o", exeFile.Name())
-		if err := asm.Run(); err != nil {
-			die("%v\n", err)
-		}
+		link(asm, exeFile.Name())
 
 		run := exec.Command(exeFile.Name())
 		run.Stdout = os.Stdout
 		run.Stderr = os.Stderr
 		if err := run.Run(); err != nil {
-			os.Remove(asmFile.Name())
+			os.Remove(asm)
 			os.Remove(exeFile.Name())
 			die("%v\n", err)
 		}
 
+	case "fmt":
+		var diff, list bool
+		var cfg ast.Config
+		var filenames []string
+		for _, a := range os.Args[2:] {
+			switch a {
+			case "-ascii":
+				cfg.Notation = ast.ASCII
+			case "-d":
+				diff = true
+			case "-l":
+				list = true
+			case "-unicode":
+				cfg.Notation = ast.Unicode
+			default:
+				filenames = append(filenames, a)
+			}
+		}
+		if len(filenames) == 0 {
+			die("lang: no lang files listed\n")
+		}
+
+		failed := false
+		for _, filename := range filenames {
+			if err := format(&cfg, filename, diff, list); err != nil {
+				printDiagnostics(err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
 	default:
 		dieUnknown()
 	}
 }
 
+// check parses and type-checks the file. The warnings are
+// printed and the errors are fatal.
+func check(filename string) (*ast.Block, types.Info) {
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		dieDiagnostics(err)
+	}
+
+	info, err := types.Check(b)
+	if err != nil {
+		dieDiagnostics(err)
+	}
+	if len(info.Warnings) > 0 {
+		printDiagnostics(errors.Errors(info.Warnings))
+	}
+	return b, info
+}
+
+// translate translates the checked block into frames.
+// The warnings of the passes are printed.
+func translate(b *ast.Block, info types.Info) []*ir.Frame {
+	var warns errors.Errors
+	frames := ir.Translate(b, info, ir.Fold(&warns), ir.Unreachable, ir.Loads)
+	if len(warns) > 0 {
+		printDiagnostics(warns)
+	}
+	return frames
+}
+
+// printFoldWarnings prints the warnings of the Fold pass, such
+// that an interpreted program is reported like a compiled one. The
+// asserts, which are always violated, are only found by propagating
+// the constants through the control flow graph of the IR, which
+// the checker does not build. Hence, the block must be translated,
+// but only the Fold pass is applied and the frames are discarded.
+func printFoldWarnings(b *ast.Block, info types.Info) {
+	var warns errors.Errors
+	ir.Translate(b, info, ir.Fold(&warns))
+	if len(warns) > 0 {
+		printDiagnostics(warns)
+	}
+}
+
+// compileAsm compiles the frames into a temporary assembly
+// file and returns its name. The caller removes the file.
+func compileAsm(filename string, frames []*ir.Frame) string {
+	asmFile, err := ioutil.TempFile("", "lang_build*.s")
+	if err != nil {
+		die("%v\n", err)
+	}
+
+	compiler.CompileProgram(asmFile, filename, frames)
+
+	if err := asmFile.Close(); err != nil {
+		die("%v\n", err)
+	}
+	return asmFile.Name()
+}
+
+// link assembles the assembly file and links it
+// with the runtime into the executable exe.
+func link(asm, exe string) {
+	rtFile, err := ioutil.TempFile("", "lang_runtime*.c")
+	if err != nil {
+		die("%v\n", err)
+	}
+	defer os.Remove(rtFile.Name())
+	if _, err := rtFile.WriteString(compiler.Runtime); err != nil {
+		die("%v\n", err)
+	}
+	if err := rtFile.Close(); err != nil {
+		die("%v\n", err)
+	}
+
+	gcc := exec.Command("gcc", "-no-pie", asm, rtFile.Name(), "-o", exe)
+	if err := gcc.Run(); err != nil {
+		die("%v\n", err)
+	}
+}
+
+// format formats the file with the configuration. If diff or list
+// is set, the file is not rewritten, but the diff or the filename
+// is printed instead, if the file is not formatted.
+func format(cfg *ast.Config, filename string, diff, list bool) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	b, comments, err := parser.Parse(bytes.NewReader(src), filename)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, b, comments); err != nil {
+		return err
+	}
+	if bytes.Equal(src, buf.Bytes()) {
+		return nil
+	}
+
+	if list {
+		fmt.Println(filename)
+	}
+	if diff {
+		f, err := ioutil.TempFile("", "lang_fmt*.l")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		d := exec.Command("diff", "-u", "--label", filename+".orig", "--label", filename, filename, f.Name())
+		d.Stdout = os.Stdout
+		d.Stderr = os.Stderr
+		// diff exits with status 1, if the files differ.
+		if err := d.Run(); err != nil {
+			if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 1 {
+				return err
+			}
+		}
+	}
+	if list || diff {
+		return nil
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), fi.Mode().Perm())
+}
+
+// jsonOutput reports whether the diagnostics are printed
+// as JSON objects, one per line, for editor integration.
+var jsonOutput bool
+
+// printDiagnostics prints the diagnostics contained in err
+// with excerpts of the source code or as JSON objects.
+func printDiagnostics(err error) {
+	if !jsonOutput {
+		errors.Print(os.Stderr, err)
+		return
+	}
+	errs, ok := err.(errors.Errors)
+	if !ok {
+		errs = errors.Errors{err}
+	}
+	enc := json.NewEncoder(os.Stderr)
+	for _, err := range errs {
+		d, ok := err.(*errors.Diagnostic)
+		if !ok {
+			d = &errors.Diagnostic{Msg: err.Error()}
+		}
+		if err := enc.Encode(d); err != nil {
+			die("%v\n", err)
+		}
+	}
+}
+
+func dieDiagnostics(err error) {
+	printDiagnostics(err)
+	os.Exit(1)
+}
+
+// dieRun reports the error of an interpreted program. Like
+// in the compiled programs, violations are printed on stdout.
+func dieRun(err error) {
+	switch err.(type) {
+	case *interp.Error, *vm.Error:
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	die("%v\n", err)
+}
+
 func die(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
@@ -113,6 +399,27 @@ func printHelp() {
 	fmt.Print(`usage: lang <cmd> [arguments]
 
 Commands:
-    run run a lang file
+    build compile a lang file
+    exec  run a bytecode file
+    fmt   format lang files
+    lsp   run a language server on stdin and stdout
+    repl  start an interactive session
+    run   run a lang file
+
+Flags of build:
+    -json          print the diagnostics as JSON objects
+    -target=native write an executable, compiled with gcc (default)
+    -target=vm     write a bytecode file with the suffix .lbc
+
+Flags of run:
+    -S      write the assembly to the file with the suffix .S
+    -interp interpret the file instead of compiling it with gcc
+    -json   print the diagnostics as JSON objects
+
+Flags of fmt:
+    -ascii   convert the operators to the ASCII notation
+    -d       print the diffs instead of rewriting the files
+    -l       list the files, which are not formatted, instead of rewriting them
+    -unicode convert the operators to the Unicode notation
 `)
 }
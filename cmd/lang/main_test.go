@@ -0,0 +1,171 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the lang command instead of the
+// tests, if the test binary is run by runLang.
+func TestMain(m *testing.M) {
+	if os.Getenv("LANG_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestAssertFalse(t *testing.T) {
+	// A func may end with assert false instead of a dummy return.
+	filename := writeFile(t, `{
+	let sign := func(x i64) i64 {
+		if x > 0 {
+			return 1;
+		} else if x < 0 {
+			return -1;
+		} else if x = 0 {
+			return 0;
+		}
+		assert false;
+	};
+	println(sign(-5), sign(0), sign(7));
+}
+`)
+
+	for _, backend := range backends(t) {
+		stdout, stderr, err := backend.run(t, filename)
+		if err != nil {
+			t.Fatalf("%s: cannot run: %v\n%s", backend.name, err, stderr)
+		}
+		if stdout != "-1 0 1\n" || stderr != "" {
+			t.Errorf("%s: expected output %q, got %q and diagnostics %q", backend.name, "-1 0 1\n", stdout, stderr)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	// The checker warns about the unreachable code and
+	// the translation about the violated assert.
+	filename := writeFile(t, `{
+	let f := func(n i64) i64 {
+		let x := 2;
+		assert x = 3;
+		return n;
+		println(n);
+	};
+	println(1);
+}
+`)
+
+	var expected string
+	for i, backend := range backends(t) {
+		stdout, stderr, err := backend.run(t, filename)
+		if err != nil {
+			t.Fatalf("%s: cannot run: %v\n%s", backend.name, err, stderr)
+		}
+		if stdout != "1\n" {
+			t.Errorf("%s: expected output %q, got %q", backend.name, "1\n", stdout)
+		}
+		if i == 0 {
+			expected = stderr
+			continue
+		}
+		if stderr != expected {
+			t.Errorf("%s: expected diagnostics\n%s\ngot\n%s", backend.name, expected, stderr)
+		}
+	}
+	if !strings.Contains(expected, "warning[L020]") || !strings.Contains(expected, "warning[L021]") {
+		t.Errorf("expected warnings about the unreachable code and the violated assert, got\n%s", expected)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	// The output before the violation is not lost.
+	filename := writeFile(t, `{
+	let a := []i64{0};
+	println(1);
+	println(2 / a[0]);
+}
+`)
+
+	expected := "1\n" + filename + ":4: division by zero\n"
+	for _, backend := range backends(t) {
+		stdout, stderr, err := backend.run(t, filename)
+		if err == nil {
+			t.Errorf("%s: expected the program to fail", backend.name)
+		}
+		if stdout != expected {
+			t.Errorf("%s: expected output %q, got %q\n%s", backend.name, expected, stdout, stderr)
+		}
+	}
+}
+
+type backend struct {
+	name string
+	run  func(t *testing.T, filename string) (stdout, stderr string, err error)
+}
+
+// backends returns the backends, which run lang files. The
+// native backend is omitted, if gcc is not available.
+func backends(t *testing.T) []backend {
+	bs := []backend{
+		{
+			name: "interp",
+			run: func(t *testing.T, filename string) (string, string, error) {
+				return runLang(t, "run", "-interp", filename)
+			},
+		},
+		{
+			name: "vm",
+			run: func(t *testing.T, filename string) (string, string, error) {
+				_, stderr, err := runLang(t, "build", "-target=vm", filename)
+				if err != nil {
+					return "", stderr, err
+				}
+				stdout, execStderr, err := runLang(t, "exec", filename+"bc")
+				return stdout, stderr + execStderr, err
+			},
+		},
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Log("gcc not found, skipping the native backend")
+		return bs
+	}
+	return append(bs, backend{
+		name: "native",
+		run: func(t *testing.T, filename string) (string, string, error) {
+			return runLang(t, "run", filename)
+		},
+	})
+}
+
+// runLang runs the lang command with the args.
+func runLang(t *testing.T, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "LANG_TEST_MAIN=1")
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	return out.String(), errOut.String(), err
+}
+
+// writeFile writes the source code to a lang file
+// in a temporary directory and returns its name.
+func writeFile(t *testing.T, src string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "a.l")
+	if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+	return filename
+}
@@ -7,28 +7,61 @@ package compiler // import "davidrjenni.io/lang/compiler"
 import (
 	"fmt"
 	"io"
+	"math"
+	"sort"
+	"strings"
 
 	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/lexer"
 )
 
-func Compile(out io.Writer, filename string, f *ir.Frame) {
-	c := &compiler{out: out}
+// CompileProgram compiles the frames of a program. The macros,
+// the text preamble, the data and the constants of all frames
+// are emitted exactly once.
+func CompileProgram(out io.Writer, filename string, frames []*ir.Frame) {
+	c := &compiler{
+		out:  out,
+		f64s: make(map[uint64]bool),
+		strs: make(map[string]int),
+	}
 	fmt.Fprint(out, macros)
 	fmt.Fprint(out, main)
-	c.compileFrame(f)
+	for _, f := range frames {
+		c.compileFrame(f)
+	}
 	fmt.Fprintf(out, data, filename)
+	c.compileConsts()
 }
 
 type compiler struct {
-	out io.Writer
+	out   io.Writer
+	alloc *allocation // allocation of the current frame
+
+	// f64 constants, identified by their bits
+	f64s    map[uint64]bool
+	f64Sign bool // whether the f64 sign mask is used
+
+	// string constants and their indices
+	strs map[string]int
 }
 
 func (c *compiler) compileFrame(f *ir.Frame) {
+	c.alloc = allocate(f.Seq)
+	if f.Name != "main" {
+		// Only main is visible to the linker.
+		c.printf(".local %s", f.Name)
+	}
 	fmt.Fprintf(c.out, "%s:\n", f.Name)
 	c.printf("%s %%rbp", Push)
 	c.printf("%s %%rsp, %%rbp", Movq)
-	if f.Stack > 0 {
-		c.printf("%s $%d, %%rsp", Sub, f.Stack)
+	if c.alloc.stack > 0 {
+		// Keep the stack 16-byte aligned for calls.
+		c.printf("%s $%d, %%rsp", Sub, (c.alloc.stack+15)&^15)
+	}
+	for _, r := range gprs {
+		if off, ok := c.alloc.saved[r]; ok {
+			c.printf("%s %s, %d(%%rbp)", Movq, r.name(ir.I64Reg), off)
+		}
 	}
 
 	for _, s := range f.Seq {
@@ -42,9 +75,40 @@ func (c *compiler) compileFrame(f *ir.Frame) {
 func (c *compiler) compile(n ir.Node) {
 	switch n := n.(type) {
 	case *ir.BinaryInstr:
-		c.printf("%s %s, %s  # %s", op(n.Op, n.RHS.Type), rval(n.LHS), reg(n.RHS), n.Pos())
+		if n.Src != nil && n.Op != ir.Cmp {
+			c.load(n.Src, n.RHS, n.Pos())
+		}
+		if n.Op == ir.Div && n.RHS.Type == ir.I64Reg {
+			c.compileDiv(n)
+			return
+		}
+		lhs, lhsMem := c.rval(n.LHS, n.Pos())
+		if isLargeI64(n.LHS) {
+			c.printf("%s %s, %s  # %s", Movq, lhs, indexScratch.name(ir.I64Reg), n.Pos())
+			lhs, lhsMem = indexScratch.name(ir.I64Reg), false
+		}
+		o := op(n.Op, n.RHS.Type)
+		dst, dstMem := c.reg(n.RHS)
+		if dstMem && (lhsMem || o.regDst()) {
+			tmp := scratch(n.RHS.Type)
+			c.printf("%s %s, %s  # %s", mov(n.RHS.Type), dst, tmp, n.Pos())
+			c.printf("%s %s, %s  # %s", o, lhs, tmp, n.Pos())
+			if n.Op != ir.Cmp {
+				c.printf("%s %s, %s  # %s", mov(n.RHS.Type), tmp, dst, n.Pos())
+			}
+			return
+		}
+		c.printf("%s %s, %s  # %s", o, lhs, dst, n.Pos())
 	case *ir.Call:
-		c.printf("%s  # %s", n.Label, n.Pos())
+		switch f := n.Func.(type) {
+		case nil:
+			c.printf("%s  # %s", n.Label, n.Pos())
+		case ir.Label:
+			c.printf("%s %s  # %s", Call, f, n.Pos())
+		default:
+			fn, _ := c.rval(f, n.Pos())
+			c.printf("%s *%s  # %s", Call, fn, n.Pos())
+		}
 	case *ir.CJump:
 		c.printf("%s %s  # %s", CJump, n.Label, n.Pos())
 	case *ir.Jump:
@@ -52,59 +116,244 @@ func (c *compiler) compile(n ir.Node) {
 	case ir.Label:
 		fmt.Fprintf(c.out, "%s:\n", n)
 	case *ir.Load:
-		c.printf("%s %s, %s  # %s", mov(n.Dst.Type), rval(n.Src), reg(n.Dst), n.Pos())
+		c.load(n.Src, n.Dst, n.Pos())
 	case *ir.Return:
+		for _, r := range gprs {
+			if off, ok := c.alloc.saved[r]; ok {
+				c.printf("%s %d(%%rbp), %s  # %s", Movq, off, r.name(ir.I64Reg), n.Pos())
+			}
+		}
 		c.printf("%s  # %s", Leave, n.Pos())
 		c.printf("%s  # %s", Ret, n.Pos())
 	case *ir.Store:
-		c.printf("%s %s, %d(%%rbp)  # %s", mov(n.Size), rval(n.Src), n.Dst.Off, n.Pos())
+		// Move the value into a scratch register first, if
+		// both operands are in memory, since the address
+		// of the destination may use the other scratch registers.
+		src, srcMem := c.rval(n.Src, n.Pos())
+		if srcMem || isLargeI64(n.Src) {
+			tmp := scratch(n.Size)
+			c.printf("%s %s, %s  # %s", mov(n.Size), src, tmp, n.Pos())
+			src = tmp
+		}
+		c.printf("%s %s, %s  # %s", mov(n.Size), src, c.mem(n.Dst, n.Pos()), n.Pos())
 	case *ir.UnaryInstr:
-		c.printf("%s %s  # %s", op(n.Op, n.Reg.Type), reg(n.Reg), n.Pos())
+		if n.Src != nil && n.Op == ir.Neg {
+			c.load(n.Src, n.Reg, n.Pos())
+		}
+		if n.Reg.Type == ir.F64Reg {
+			c.compileF64UnaryInstr(n)
+			return
+		}
+		r, _ := c.reg(n.Reg)
+		c.printf("%s %s  # %s", op(n.Op, n.Reg.Type), r, n.Pos())
 	default:
 		panic(fmt.Sprintf("unexpected type %T", n))
 	}
 }
 
-func reg(r *ir.Reg) string {
-	switch r.Type {
-	case ir.BoolReg:
-		if r.Second {
-			return "%bl"
-		}
-		return "%al"
-	case ir.F64Reg:
-		if r.Second {
-			return "%xmm1"
-		}
-		return "%xmm0"
-	case ir.I64Reg:
-		if r.Second {
-			return "%rbx"
+// load moves the value into the register.
+func (c *compiler) load(v ir.RVal, r *ir.Reg, pos lexer.Pos) {
+	src, srcMem := c.rval(v, pos)
+	dst, dstMem := c.reg(r)
+	if src == dst {
+		return
+	}
+	if dstMem && (srcMem || isLargeI64(v)) {
+		tmp := scratch(r.Type)
+		c.printf("%s %s, %s  # %s", mov(r.Type), src, tmp, pos)
+		src = tmp
+	}
+	c.printf("%s %s, %s  # %s", mov(r.Type), src, dst, pos)
+}
+
+// compileDiv compiles an i64 division, which divides
+// %rdx:%rax and stores the quotient in %rax.
+func (c *compiler) compileDiv(n *ir.BinaryInstr) {
+	divisor, _ := c.rval(n.LHS, n.Pos())
+	if _, ok := n.LHS.(ir.I64); ok {
+		c.printf("%s %s, %s  # %s", Movq, divisor, indexScratch.name(ir.I64Reg), n.Pos())
+		divisor = indexScratch.name(ir.I64Reg)
+	}
+	dst, _ := c.reg(n.RHS)
+	c.printf("%s %s, %%rax  # %s", Movq, dst, n.Pos())
+	// Sign-extend the dividend in %rax into %rdx:%rax.
+	c.printf("%s  # %s", Cqto, n.Pos())
+	c.printf("%s %s  # %s", Div, divisor, n.Pos())
+	c.printf("%s %%rax, %s  # %s", Movq, dst, n.Pos())
+}
+
+// compileF64UnaryInstr compiles the unary instructions, for
+// which SSE has no equivalent instruction on a single register.
+func (c *compiler) compileF64UnaryInstr(n *ir.UnaryInstr) {
+	switch n.Op {
+	case ir.Neg:
+		// Flip the sign bit.
+		c.f64Sign = true
+		r, inMem := c.reg(n.Reg)
+		if !inMem {
+			c.printf("%s %s(%%rip), %s  # %s", Xorpd, f64Sign, r, n.Pos())
+			return
 		}
-		return "%rax"
+		tmp := xmmScratch.name(ir.F64Reg)
+		c.printf("%s %s, %s  # %s", Movsd, r, tmp, n.Pos())
+		c.printf("%s %s(%%rip), %s  # %s", Xorpd, f64Sign, tmp, n.Pos())
+		c.printf("%s %s, %s  # %s", Movsd, tmp, r, n.Pos())
 	default:
-		panic(fmt.Sprintf("unexpected type %d", r.Type))
+		panic(fmt.Sprintf("unexpected %s reg for op %s", n.Reg.Type, n.Op))
+	}
+}
+
+// compileConsts emits the f64 and string
+// constants into the read-only data section.
+func (c *compiler) compileConsts() {
+	if len(c.f64s) == 0 && !c.f64Sign && len(c.strs) == 0 {
+		return
+	}
+	fmt.Fprint(c.out, rodata)
+	if c.f64Sign {
+		c.printf(".align 16")
+		fmt.Fprintf(c.out, "%s: .quad 0x%016x, 0\n", f64Sign, uint64(1)<<63)
+	}
+	bits := make([]uint64, 0, len(c.f64s))
+	for b := range c.f64s {
+		bits = append(bits, b)
+	}
+	sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+	if len(bits) > 0 {
+		c.printf(".align 8")
+	}
+	for _, b := range bits {
+		fmt.Fprintf(c.out, "%s: .quad 0x%016x  # %v\n", f64Label(b), b, math.Float64frombits(b))
+	}
+
+	strs := make([]string, len(c.strs))
+	for s, i := range c.strs {
+		strs[i] = s
+	}
+	for i, s := range strs {
+		fmt.Fprintf(c.out, "%s: .string %s\n", strLabel(i), asmString(s))
+	}
+}
+
+func f64Label(bits uint64) string {
+	return fmt.Sprintf("___f64_%016x", bits)
+}
+
+func strLabel(i int) string {
+	return fmt.Sprintf("___str_%d", i)
+}
+
+// asmString quotes the string for the assembler, escaping
+// all bytes, which are not printable ASCII characters.
+func asmString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < ' ' || ch > '~':
+			fmt.Fprintf(&b, "\\%03o", ch)
+		default:
+			b.WriteByte(ch)
+		}
 	}
+	b.WriteByte('"')
+	return b.String()
 }
 
-func rval(v ir.RVal) string {
+// reg returns the operand of the register. The operand is in
+// memory, if the register is a spilled virtual register.
+func (c *compiler) reg(r *ir.Reg) (string, bool) {
+	if r.Virt == 0 {
+		return fixed(r).name(r.Type), false
+	}
+	if p, ok := c.alloc.regs[r.Virt]; ok {
+		return p.name(r.Type), false
+	}
+	off, ok := c.alloc.slots[r.Virt]
+	if !ok {
+		panic(fmt.Sprintf("unallocated register %d", r.Virt))
+	}
+	return fmt.Sprintf("%d(%%rbp)", off), true
+}
+
+// rval returns the operand of the value and whether it is in memory.
+func (c *compiler) rval(v ir.RVal, pos lexer.Pos) (string, bool) {
 	switch v := v.(type) {
 	case ir.Bool:
 		if v {
-			return "$1"
+			return "$1", false
 		}
-		return "$0"
+		return "$0", false
+	case ir.F64:
+		bits := math.Float64bits(float64(v))
+		c.f64s[bits] = true
+		return fmt.Sprintf("%s(%%rip)", f64Label(bits)), true
 	case ir.I64:
-		return fmt.Sprintf("$%d", v)
+		return fmt.Sprintf("$%d", v), false
+	case ir.Label:
+		return fmt.Sprintf("$%s", v), false
+	case ir.String:
+		i, ok := c.strs[string(v)]
+		if !ok {
+			i = len(c.strs)
+			c.strs[string(v)] = i
+		}
+		return fmt.Sprintf("$%s", strLabel(i)), false
 	case *ir.Mem:
-		return fmt.Sprintf("%d(%%rbp)", v.Off)
+		return c.mem(v, pos), true
 	case *ir.Reg:
-		return reg(v)
+		return c.reg(v)
 	default:
 		panic(fmt.Sprintf("unexpected type %T", v))
 	}
 }
 
+// mem returns the operand of the memory. Spilled base and
+// index registers are loaded into scratch registers first.
+func (c *compiler) mem(m *ir.Mem, pos lexer.Pos) string {
+	if m.Base == nil {
+		return fmt.Sprintf("%d(%%rbp)", m.Off)
+	}
+	base := c.addr(m.Base, baseScratch, pos)
+	if m.Index != nil {
+		index := c.addr(m.Index, indexScratch, pos)
+		return fmt.Sprintf("%d(%s,%s,%d)", m.Off, base, index, m.Scale)
+	}
+	if m.Off == 0 {
+		return fmt.Sprintf("(%s)", base)
+	}
+	return fmt.Sprintf("%d(%s)", m.Off, base)
+}
+
+// addr returns the register holding the address or index.
+func (c *compiler) addr(r *ir.Reg, tmp physReg, pos lexer.Pos) string {
+	operand, inMem := c.reg(r)
+	if !inMem {
+		return operand
+	}
+	c.printf("%s %s, %s  # %s", Movq, operand, tmp.name(ir.I64Reg), pos)
+	return tmp.name(ir.I64Reg)
+}
+
+// scratch returns the scratch register for values of the given type.
+func scratch(t ir.RegType) string {
+	if t == ir.F64Reg {
+		return xmmScratch.name(t)
+	}
+	return gprScratch.name(t)
+}
+
+// isLargeI64 reports whether the value is an i64 constant, which
+// does not fit into the sign-extended 32-bit immediate of most
+// instructions. Such constants can only be moved into registers.
+func isLargeI64(v ir.RVal) bool {
+	i, ok := v.(ir.I64)
+	return ok && int64(int32(i)) != int64(i)
+}
+
 func (c *compiler) printf(f string, args ...interface{}) {
 	fmt.Fprintf(c.out, "\t%s\n", fmt.Sprintf(f, args...))
 }
@@ -118,7 +367,26 @@ const macros = `
 .macro AssertViolated
     movq $___fmt_assert, %rdi
     movq $___filename, %rsi
-    movq %rbx, %rdx
+    movq $0, %rax
+    call printf
+    movq $1, %rdi
+    movq $0, %rax
+    call exit
+.endm
+
+.macro BoundsViolated
+    movq $___fmt_bounds, %rdi
+    movq $___filename, %rsi
+    movq $0, %rax
+    call printf
+    movq $1, %rdi
+    movq $0, %rax
+    call exit
+.endm
+
+.macro DivisionByZero
+    movq $___fmt_division, %rdi
+    movq $___filename, %rsi
     movq $0, %rax
     call printf
     movq $1, %rdi
@@ -127,8 +395,16 @@ const macros = `
 .endm
 `
 
+const rodata = `
+	.section .rodata
+`
+
+const f64Sign = "___f64_sign"
+
 const data = `
 	.section .data
-___fmt_assert: .string "%%s:%%d: assertion violated\n"
-___filename:   .string %q
+___fmt_assert:   .string "%%s:%%d: assertion violated\n"
+___fmt_bounds:   .string "%%s:%%d: index out of bounds\n"
+___fmt_division: .string "%%s:%%d: division by zero\n"
+___filename:     .string %q
 `
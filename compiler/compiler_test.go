@@ -34,9 +34,7 @@ func TestCompile(t *testing.T) {
 	frames := ir.Translate(b, info)
 
 	var actual bytes.Buffer
-	for _, f := range frames {
-		compiler.Compile(&actual, filename, f)
-	}
+	compiler.CompileProgram(&actual, filename, frames)
 
 	golden := filepath.Join("test-fixtures", "input.golden")
 	if *update {
@@ -0,0 +1,181 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compiler // import "davidrjenni.io/lang/compiler"
+
+import (
+	"sort"
+
+	"davidrjenni.io/lang/ir"
+)
+
+// interval is the live range of a virtual register. It spans the
+// indices of all instructions, at which the register is live.
+type interval struct {
+	virt       int
+	typ        ir.RegType
+	start, end int
+}
+
+// intervals computes the live intervals of the virtual
+// registers in the sequence, ordered by their start.
+func intervals(seq ir.Seq) []*interval {
+	n := 0
+	types := make(map[int]ir.RegType)
+	uses := make([]bitset, len(seq))
+	defs := make([]bitset, len(seq))
+	for i, node := range seq {
+		u, d := ir.Operands(node)
+		for _, r := range append(u, d...) {
+			if r.Virt > 0 {
+				types[r.Virt] = r.Type
+				if r.Virt >= n {
+					n = r.Virt + 1
+				}
+			}
+		}
+		uses[i], defs[i] = virtSet(u), virtSet(d)
+	}
+	for i := range seq {
+		uses[i], defs[i] = uses[i].grow(n), defs[i].grow(n)
+	}
+
+	// Solve the backward dataflow equations
+	//   out[i] = ∪ in[s] for all successors s of i
+	//   in[i]  = uses[i] ∪ (out[i] - defs[i])
+	// by iterating until a fixed point is reached.
+	succs := successors(seq)
+	in := make([]bitset, len(seq))
+	out := make([]bitset, len(seq))
+	for i := range seq {
+		in[i] = newBitset(n)
+		out[i] = newBitset(n)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(seq) - 1; i >= 0; i-- {
+			for _, s := range succs[i] {
+				out[i].union(in[s])
+			}
+			live := newBitset(n)
+			live.union(out[i])
+			live.diff(defs[i])
+			live.union(uses[i])
+			if !live.equal(in[i]) {
+				in[i] = live
+				changed = true
+			}
+		}
+	}
+
+	ivs := make(map[int]*interval)
+	extend := func(v, i int) {
+		iv, ok := ivs[v]
+		if !ok {
+			ivs[v] = &interval{virt: v, typ: types[v], start: i, end: i}
+			return
+		}
+		if i < iv.start {
+			iv.start = i
+		}
+		if i > iv.end {
+			iv.end = i
+		}
+	}
+	for i := range seq {
+		for v := 0; v < n; v++ {
+			if in[i].has(v) || defs[i].has(v) {
+				extend(v, i)
+			}
+		}
+	}
+
+	sorted := make([]*interval, 0, len(ivs))
+	for _, iv := range ivs {
+		sorted = append(sorted, iv)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].start != sorted[j].start {
+			return sorted[i].start < sorted[j].start
+		}
+		return sorted[i].virt < sorted[j].virt
+	})
+	return sorted
+}
+
+// successors returns the indices of the successors of the instructions.
+// The macros are assumed to return, although they exit the program.
+func successors(seq ir.Seq) [][]int {
+	labels := make(map[ir.Label]int)
+	for i, n := range seq {
+		if l, ok := n.(ir.Label); ok {
+			labels[l] = i
+		}
+	}
+	succs := make([][]int, len(seq))
+	for i, n := range seq {
+		switch n := n.(type) {
+		case *ir.Jump:
+			succs[i] = []int{labels[n.Label]}
+			continue
+		case *ir.CJump:
+			succs[i] = []int{labels[n.Label]}
+		case *ir.Return:
+			continue
+		}
+		if i+1 < len(seq) {
+			succs[i] = append(succs[i], i+1)
+		}
+	}
+	return succs
+}
+
+func virtSet(regs []*ir.Reg) bitset {
+	var b bitset
+	for _, r := range regs {
+		if r.Virt > 0 {
+			b = b.grow(r.Virt + 1)
+			b.add(r.Virt)
+		}
+	}
+	return b
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+// grow returns the set with room for n elements.
+func (b bitset) grow(n int) bitset {
+	if w := (n + 63) / 64; w > len(b) {
+		return append(b, make(bitset, w-len(b))...)
+	}
+	return b
+}
+
+func (b bitset) add(i int)      { b[i/64] |= 1 << (i % 64) }
+func (b bitset) has(i int) bool { return i/64 < len(b) && b[i/64]&(1<<(i%64)) != 0 }
+
+func (b bitset) union(c bitset) {
+	for i := range c {
+		b[i] |= c[i]
+	}
+}
+
+func (b bitset) diff(c bitset) {
+	for i := range c {
+		b[i] &^= c[i]
+	}
+}
+
+func (b bitset) equal(c bitset) bool {
+	for i := range b {
+		if b[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -15,8 +15,9 @@ import (
 type Op int
 
 const (
-	Movq Op = iota // movq
-	Movb           // movb
+	Movq  Op = iota // movq
+	Movb            // movb
+	Movsd           // movsd
 
 	Push // pushq
 	Pop  // popq
@@ -26,16 +27,24 @@ const (
 
 	Neg // negq
 
-	Add // addq
-	Sub // subq
-	Mul // imulq
-	Div // idiv
+	Add  // addq
+	Sub  // subq
+	Mul  // imulq
+	Div  // idivq
+	Cqto // cqto
+
+	Addsd // addsd
+	Subsd // subsd
+	Mulsd // mulsd
+	Divsd // divsd
+	Xorpd // xorpd
 
 	And // andb
 	Or  // orb
 
-	Cmpq // cmpq
-	Cmpb // cmpb
+	Cmpq    // cmpq
+	Cmpb    // cmpb
+	Ucomisd // ucomisd
 
 	Setl  // setl
 	Setle // setle
@@ -43,6 +52,10 @@ const (
 	Setne // setne
 	Setg  // setg
 	Setge // setge
+	Seta  // seta
+	Setae // setae
+	Setp  // setp
+	Setnp // setnp
 
 	Call // call
 
@@ -51,19 +64,16 @@ const (
 )
 
 var ops = map[ir.Op]map[ir.RegType]Op{
-	ir.Push: {ir.I64Reg: Push},
-	ir.Pop:  {ir.I64Reg: Pop},
-
 	ir.Neg: {ir.I64Reg: Neg},
 
-	ir.Add: {ir.I64Reg: Add},
-	ir.Sub: {ir.I64Reg: Sub},
-	ir.Mul: {ir.I64Reg: Mul},
-	ir.Div: {ir.I64Reg: Div},
+	ir.Add: {ir.I64Reg: Add, ir.F64Reg: Addsd},
+	ir.Sub: {ir.I64Reg: Sub, ir.F64Reg: Subsd},
+	ir.Mul: {ir.I64Reg: Mul, ir.F64Reg: Mulsd},
+	ir.Div: {ir.I64Reg: Div, ir.F64Reg: Divsd},
 
 	ir.And: {ir.BoolReg: And},
 	ir.Or:  {ir.BoolReg: Or},
-	ir.Cmp: {ir.I64Reg: Cmpq, ir.BoolReg: Cmpb},
+	ir.Cmp: {ir.I64Reg: Cmpq, ir.BoolReg: Cmpb, ir.F64Reg: Ucomisd},
 
 	ir.Setl:  {ir.BoolReg: Setl},
 	ir.Setle: {ir.BoolReg: Setle},
@@ -71,12 +81,28 @@ var ops = map[ir.Op]map[ir.RegType]Op{
 	ir.Setne: {ir.BoolReg: Setne},
 	ir.Setg:  {ir.BoolReg: Setg},
 	ir.Setge: {ir.BoolReg: Setge},
+	ir.Seta:  {ir.BoolReg: Seta},
+	ir.Setae: {ir.BoolReg: Setae},
+	ir.Setp:  {ir.BoolReg: Setp},
+	ir.Setnp: {ir.BoolReg: Setnp},
+}
+
+// regDst reports whether the destination of the op must be a register.
+func (o Op) regDst() bool {
+	switch o {
+	case Mul, Addsd, Subsd, Mulsd, Divsd, Ucomisd:
+		return true
+	default:
+		return false
+	}
 }
 
 func mov(t ir.RegType) Op {
 	switch t {
 	case ir.BoolReg:
 		return Movb
+	case ir.F64Reg:
+		return Movsd
 	case ir.I64Reg:
 		return Movq
 	default:
@@ -10,33 +10,45 @@ func _() {
 	var x [1]struct{}
 	_ = x[Movq-0]
 	_ = x[Movb-1]
-	_ = x[Push-2]
-	_ = x[Pop-3]
-	_ = x[Jump-4]
-	_ = x[CJump-5]
-	_ = x[Neg-6]
-	_ = x[Add-7]
-	_ = x[Sub-8]
-	_ = x[Mul-9]
-	_ = x[Div-10]
-	_ = x[And-11]
-	_ = x[Or-12]
-	_ = x[Cmpq-13]
-	_ = x[Cmpb-14]
-	_ = x[Setl-15]
-	_ = x[Setle-16]
-	_ = x[Sete-17]
-	_ = x[Setne-18]
-	_ = x[Setg-19]
-	_ = x[Setge-20]
-	_ = x[Call-21]
-	_ = x[Leave-22]
-	_ = x[Ret-23]
+	_ = x[Movsd-2]
+	_ = x[Push-3]
+	_ = x[Pop-4]
+	_ = x[Jump-5]
+	_ = x[CJump-6]
+	_ = x[Neg-7]
+	_ = x[Add-8]
+	_ = x[Sub-9]
+	_ = x[Mul-10]
+	_ = x[Div-11]
+	_ = x[Cqto-12]
+	_ = x[Addsd-13]
+	_ = x[Subsd-14]
+	_ = x[Mulsd-15]
+	_ = x[Divsd-16]
+	_ = x[Xorpd-17]
+	_ = x[And-18]
+	_ = x[Or-19]
+	_ = x[Cmpq-20]
+	_ = x[Cmpb-21]
+	_ = x[Ucomisd-22]
+	_ = x[Setl-23]
+	_ = x[Setle-24]
+	_ = x[Sete-25]
+	_ = x[Setne-26]
+	_ = x[Setg-27]
+	_ = x[Setge-28]
+	_ = x[Seta-29]
+	_ = x[Setae-30]
+	_ = x[Setp-31]
+	_ = x[Setnp-32]
+	_ = x[Call-33]
+	_ = x[Leave-34]
+	_ = x[Ret-35]
 }
 
-const _Op_name = "movqmovbpushqpopqjmpjenegqaddqsubqimulqidivandborbcmpqcmpbsetlsetlesetesetnesetgsetgecallleaveret"
+const _Op_name = "movqmovbmovsdpushqpopqjmpjenegqaddqsubqimulqidivqcqtoaddsdsubsdmulsddivsdxorpdandborbcmpqcmpbucomisdsetlsetlesetesetnesetgsetgesetasetaesetpsetnpcallleaveret"
 
-var _Op_index = [...]uint8{0, 4, 8, 13, 17, 20, 22, 26, 30, 34, 39, 43, 47, 50, 54, 58, 62, 67, 71, 76, 80, 85, 89, 94, 97}
+var _Op_index = [...]uint8{0, 4, 8, 13, 18, 22, 25, 27, 31, 35, 39, 44, 49, 53, 58, 63, 68, 73, 78, 82, 85, 89, 93, 100, 104, 109, 113, 118, 122, 127, 131, 136, 140, 145, 149, 154, 157}
 
 func (i Op) String() string {
 	if i < 0 || i >= Op(len(_Op_index)-1) {
@@ -0,0 +1,281 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compiler // import "davidrjenni.io/lang/compiler"
+
+import (
+	"fmt"
+
+	"davidrjenni.io/lang/ir"
+)
+
+type physReg int
+
+const (
+	rax physReg = iota
+	rbx
+	rcx
+	rdx
+	rsi
+	rdi
+	r8
+	r9
+	r10
+	r11
+	r12
+	r13
+	r14
+	r15
+	xmm0
+	xmm1
+	xmm2
+	xmm3
+	xmm4
+	xmm5
+	xmm6
+	xmm7
+	xmm8
+	xmm9
+	xmm10
+	xmm11
+	xmm12
+	xmm13
+	xmm14
+	xmm15
+)
+
+var gprNames = [...]struct{ i64, bool string }{
+	rax: {"%rax", "%al"},
+	rbx: {"%rbx", "%bl"},
+	rcx: {"%rcx", "%cl"},
+	rdx: {"%rdx", "%dl"},
+	rsi: {"%rsi", "%sil"},
+	rdi: {"%rdi", "%dil"},
+	r8:  {"%r8", "%r8b"},
+	r9:  {"%r9", "%r9b"},
+	r10: {"%r10", "%r10b"},
+	r11: {"%r11", "%r11b"},
+	r12: {"%r12", "%r12b"},
+	r13: {"%r13", "%r13b"},
+	r14: {"%r14", "%r14b"},
+	r15: {"%r15", "%r15b"},
+}
+
+func (r physReg) name(t ir.RegType) string {
+	if r >= xmm0 {
+		return fmt.Sprintf("%%xmm%d", r-xmm0)
+	}
+	if t == ir.BoolReg {
+		return gprNames[r].bool
+	}
+	return gprNames[r].i64
+}
+
+func (r physReg) calleeSaved() bool {
+	return r == rbx || (r12 <= r && r <= r15)
+}
+
+var (
+	// The allocatable registers. The caller-saved registers come
+	// first, since they need not be saved in the prologue. %rax and
+	// %xmm0 hold results, %rax and %rdx are used by divisions and
+	// the scratch registers are used, if operands are spilled.
+	gprs = []physReg{rcx, rsi, rdi, r8, r9, rbx, r12, r13, r14, r15}
+	xmms = []physReg{xmm1, xmm2, xmm3, xmm4, xmm5, xmm6, xmm7, xmm8, xmm9, xmm10, xmm11, xmm12, xmm13, xmm14}
+
+	// The argument registers, following the System V calling convention.
+	argGPRs = []physReg{rdi, rsi, rdx, rcx, r8, r9}
+	argXMMs = []physReg{xmm0, xmm1, xmm2, xmm3, xmm4, xmm5, xmm6, xmm7}
+
+	// The scratch registers for spilled addresses and values.
+	baseScratch  = r10
+	indexScratch = r11
+	gprScratch   = rax
+	xmmScratch   = xmm15
+)
+
+// fixed returns the physical register of an argument or result register.
+func fixed(r *ir.Reg) physReg {
+	if r.Arg == 0 {
+		if r.Type == ir.F64Reg {
+			return xmm0
+		}
+		return rax
+	}
+	regs := argGPRs
+	if r.Type == ir.F64Reg {
+		regs = argXMMs
+	}
+	if r.Arg > len(regs) {
+		panic(fmt.Sprintf("unexpected %s argument register %d", r.Type, r.Arg))
+	}
+	return regs[r.Arg-1]
+}
+
+// allocation maps the virtual registers of a
+// frame to physical registers or stack slots.
+type allocation struct {
+	regs  map[int]physReg // registers of the virtual registers
+	slots map[int]int     // stack slots of the spilled virtual registers
+	saved map[physReg]int // stack slots of the used callee-saved registers
+	stack int             // size of the stack frame
+}
+
+// allocate allocates the registers of the virtual registers in the
+// sequence by linear scan. If no register is free, the interval
+// ending last is spilled into a stack slot.
+func allocate(seq ir.Seq) *allocation {
+	a := &allocation{
+		regs:  make(map[int]physReg),
+		slots: make(map[int]int),
+		saved: make(map[physReg]int),
+	}
+	rs := fixedRanges(seq)
+	spill := func(iv *interval) {
+		a.stack += 8
+		a.slots[iv.virt] = -a.stack
+		delete(a.regs, iv.virt)
+	}
+
+	var active []*interval
+	for _, iv := range intervals(seq) {
+		// Expire the intervals ending before the current one.
+		live := active[:0]
+		for _, act := range active {
+			if act.end >= iv.start {
+				live = append(live, act)
+			}
+		}
+		active = live
+
+		pool := gprs
+		if iv.typ == ir.F64Reg {
+			pool = xmms
+		}
+		used := make(map[physReg]bool)
+		for _, act := range active {
+			used[a.regs[act.virt]] = true
+		}
+
+		free := false
+		for _, r := range pool {
+			if !used[r] && !rs.conflicts(r, iv) {
+				a.regs[iv.virt] = r
+				free = true
+				break
+			}
+		}
+		if free {
+			active = append(active, iv)
+			continue
+		}
+
+		// Spill the interval ending last, whose register
+		// is not used as fixed register by the current one.
+		var victim *interval
+		for _, act := range active {
+			r := a.regs[act.virt]
+			if !inPool(r, pool) || rs.conflicts(r, iv) {
+				continue
+			}
+			if victim == nil || act.end > victim.end {
+				victim = act
+			}
+		}
+		if victim == nil || victim.end <= iv.end {
+			spill(iv)
+			continue
+		}
+		a.regs[iv.virt] = a.regs[victim.virt]
+		spill(victim)
+		for i, act := range active {
+			if act == victim {
+				active[i] = iv
+			}
+		}
+	}
+
+	used := make(map[physReg]bool)
+	for _, r := range a.regs {
+		used[r] = true
+	}
+	for _, r := range gprs {
+		if used[r] && r.calleeSaved() {
+			a.stack += 8
+			a.saved[r] = -a.stack
+		}
+	}
+	return a
+}
+
+func inPool(r physReg, pool []physReg) bool {
+	for _, p := range pool {
+		if p == r {
+			return true
+		}
+	}
+	return false
+}
+
+// ranges contains the ranges of instruction indices,
+// in which physical registers are not allocatable.
+type ranges map[physReg][][2]int
+
+func (rs ranges) add(r physReg, start, end int) {
+	rs[r] = append(rs[r], [2]int{start, end})
+}
+
+func (rs ranges) conflicts(r physReg, iv *interval) bool {
+	for _, rg := range rs[r] {
+		if rg[0] <= iv.end && iv.start <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// fixedRanges returns the ranges, in which the argument registers
+// hold args and in which calls clobber the caller-saved registers.
+// The args are loaded into the argument registers immediately
+// before calls and the params are moved out of them at the start
+// of a frame, hence the ranges are computed in a single pass.
+func fixedRanges(seq ir.Seq) ranges {
+	rs := make(ranges)
+	loaded := make(map[physReg]int)
+	for i, n := range seq {
+		uses, defs := ir.Operands(n)
+		for _, r := range uses {
+			if r.Virt > 0 {
+				continue
+			}
+			p := fixed(r)
+			start, ok := loaded[p]
+			if !ok {
+				start = 0 // params are live from the start of the frame
+			}
+			rs.add(p, start, i)
+			delete(loaded, p)
+		}
+		if _, ok := n.(*ir.Call); ok {
+			for p, start := range loaded {
+				rs.add(p, start, i)
+			}
+			loaded = make(map[physReg]int)
+			for _, p := range append(gprs, xmms...) {
+				if !p.calleeSaved() {
+					rs.add(p, i, i)
+				}
+			}
+		}
+		for _, r := range defs {
+			if r.Virt == 0 {
+				loaded[fixed(r)] = i
+			}
+		}
+	}
+	for p, start := range loaded {
+		rs.add(p, start, len(seq))
+	}
+	return rs
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"path/filepath"
+	"testing"
+
+	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+func TestAllocate(t *testing.T) {
+	filename := filepath.Join("test-fixtures", "input.l")
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
+	}
+
+	info, err := types.Check(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, f := range ir.Translate(b, info, ir.Loads) {
+		a := allocate(f.Seq)
+		ivs := intervals(f.Seq)
+		for i, iv := range ivs {
+			r, ok := a.regs[iv.virt]
+			if !ok {
+				if _, ok := a.slots[iv.virt]; !ok {
+					t.Errorf("%s: register %d is not allocated", f.Name, iv.virt)
+				}
+				continue
+			}
+			for j, n := range f.Seq {
+				if _, ok := n.(*ir.Call); ok && iv.start < j && j < iv.end && !r.calleeSaved() {
+					t.Errorf("%s: register %d in %s is clobbered by call at %d", f.Name, iv.virt, r.name(iv.typ), j)
+				}
+			}
+			for _, other := range ivs[i+1:] {
+				if other.start > iv.end {
+					break
+				}
+				if o, ok := a.regs[other.virt]; ok && o == r {
+					t.Errorf("%s: registers %d and %d overlap in %s", f.Name, iv.virt, other.virt, r.name(iv.typ))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compiler // import "davidrjenni.io/lang/compiler"
+
+import _ "embed"
+
+// Runtime is the C source code of the runtime,
+// which must be linked with the compiled program.
+//
+//go:embed runtime/runtime.c
+var Runtime string
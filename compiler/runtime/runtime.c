@@ -0,0 +1,127 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The runtime provides the operations of lang programs, which
+// are too complex to emit inline. It is linked with the program.
+
+#include <inttypes.h>
+#include <math.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// lang_concat returns a newly allocated string, which
+// consists of the string a followed by the string b.
+char *lang_concat(const char *a, const char *b) {
+	size_t n = strlen(a), m = strlen(b);
+	char *s = malloc(n + m + 1);
+	memcpy(s, a, n);
+	memcpy(s + n, b, m + 1);
+	return s;
+}
+
+// lang_strcmp compares the strings a and b lexicographically
+// and returns a negative value, zero or a positive value,
+// if a is less than, equal to or greater than b.
+int64_t lang_strcmp(const char *a, const char *b) {
+	return strcmp(a, b);
+}
+
+// lang_substring reports whether the string s is a substring of t.
+int64_t lang_substring(const char *s, const char *t) {
+	return strstr(t, s) != NULL;
+}
+
+// The arrays passed to the lang_elem_* funcs consist
+// of their length followed by their elems.
+
+// lang_elem_bool reports whether x is an elem of the array a.
+int64_t lang_elem_bool(uint8_t x, const int64_t *a) {
+	const uint8_t *elems = (const uint8_t *)(a + 1);
+	for (int64_t i = 0; i < a[0]; i++) {
+		if (elems[i] == x) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// lang_elem_f64 reports whether x is an elem of the array a.
+int64_t lang_elem_f64(double x, const int64_t *a) {
+	const double *elems = (const double *)(a + 1);
+	for (int64_t i = 0; i < a[0]; i++) {
+		if (elems[i] == x) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// lang_elem_i64 reports whether x is an elem of the array a.
+int64_t lang_elem_i64(int64_t x, const int64_t *a) {
+	for (int64_t i = 0; i < a[0]; i++) {
+		if (a[i + 1] == x) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// lang_elem_string reports whether s is an elem of the array a.
+int64_t lang_elem_string(const char *s, const int64_t *a) {
+	const char *const *elems = (const char *const *)(a + 1);
+	for (int64_t i = 0; i < a[0]; i++) {
+		if (strcmp(elems[i], s) == 0) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// lang_print_bool prints the bool b, which is passed in the low byte.
+void lang_print_bool(uint8_t b) {
+	fputs(b ? "true" : "false", stdout);
+}
+
+// lang_print_f64 prints the f64 x with the fewest digits, which
+// represent x exactly. Like Go's %v, large and small exponents
+// are printed in scientific notation.
+void lang_print_f64(double x) {
+	if (isnan(x)) {
+		fputs("NaN", stdout);
+		return;
+	}
+	if (isinf(x)) {
+		fputs(x > 0 ? "+Inf" : "-Inf", stdout);
+		return;
+	}
+
+	char buf[32];
+	int prec = 1;
+	for (; prec < 17; prec++) {
+		snprintf(buf, sizeof(buf), "%.*e", prec - 1, x);
+		if (strtod(buf, NULL) == x) {
+			break;
+		}
+	}
+	snprintf(buf, sizeof(buf), "%.*e", prec - 1, x);
+	int exp = atoi(strchr(buf, 'e') + 1);
+	if (exp < -4 || exp >= 6) {
+		fputs(buf, stdout);
+		return;
+	}
+	int decimals = prec - 1 - exp;
+	printf("%.*f", decimals > 0 ? decimals : 0, x);
+}
+
+// lang_print_i64 prints the i64 x.
+void lang_print_i64(int64_t x) {
+	printf("%" PRId64, x);
+}
+
+// lang_print_string prints the string s.
+void lang_print_string(const char *s) {
+	fputs(s, stdout);
+}
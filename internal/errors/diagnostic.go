@@ -0,0 +1,113 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package errors // import "davidrjenni.io/lang/internal/errors"
+
+import (
+	"fmt"
+
+	"davidrjenni.io/lang/lexer"
+)
+
+// Diagnostic is an error or a warning in the source code.
+// It spans the source code from Pos up to End.
+type Diagnostic struct {
+	Pos      lexer.Pos `json:"pos"`
+	End      lexer.Pos `json:"end"`
+	Severity Severity  `json:"severity"`
+	Code     Code      `json:"code,omitempty"`
+	Msg      string    `json:"msg"`
+	Notes    []string  `json:"notes,omitempty"`
+	Related  []Related `json:"related,omitempty"`
+}
+
+// Related is a position, which is related to a diagnostic,
+// e.g. the previous definition of an identifier.
+type Related struct {
+	Pos lexer.Pos `json:"pos"`
+	Msg string    `json:"msg"`
+}
+
+// Error returns the message, starting with the position.
+func (d *Diagnostic) Error() string {
+	return d.Pos.String() + ": " + d.Msg
+}
+
+// Note adds a note, formatted according to the
+// format string and the arguments given.
+func (d *Diagnostic) Note(format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, fmt.Sprintf(format, args...))
+	return d
+}
+
+// Relate adds a related position with a message, formatted
+// according to the format string and the arguments given.
+func (d *Diagnostic) Relate(pos lexer.Pos, format string, args ...interface{}) *Diagnostic {
+	d.Related = append(d.Related, Related{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+	return d
+}
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Code identifies the kind of a diagnostic. The codes are stable,
+// i.e. new codes are appended and codes are never reused.
+type Code int
+
+const (
+	_ Code = iota
+
+	// Syntax errors.
+	InvalidToken    // illegal characters or malformed literals
+	UnexpectedToken // tokens violating the grammar
+
+	// Type errors.
+	UndefinedIdent    // use of an undefined identifier
+	AlreadyDefined    // redefinition of an identifier
+	UncalledBuiltin   // use of a builtin func as value
+	VoidValue         // use of a void expr as value
+	UnusedExpr        // expr cmd, which is not a call
+	IncompatibleTypes // value not assignable to the expected type
+	InvalidOp         // operator not applicable to the operands
+	NonBoolCond       // condition not of type bool
+	InvalidIndex      // index expr on an invalid expr or index
+	InvalidArrayLit   // array literal with a wrong number of elems
+	InvalidArrayLen   // array type with an invalid length
+	InvalidCall       // call of an invalid expr or with invalid args
+	MisplacedCmd      // break, continue or return outside of its scope
+	InvalidAssign     // assignment to an expr, which cannot be assigned
+	InvalidIsExpr     // is expr on an expr of a non-union type
+	MissingReturn     // func, which does not return on all paths
+	TooManyParams     // func with more params than registers
+
+	// Warnings.
+	UnreachableCode // cmd, which is never executed
+
+	// Warnings detected during translation. They are not errors,
+	// since the funcs containing them may never be called.
+	ViolatedAssert // assert, which is always violated
+)
+
+func (c Code) String() string {
+	return fmt.Sprintf("L%03d", int(c))
+}
+
+func (c Code) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
@@ -14,10 +14,14 @@ import (
 // Errors is a slice of errors.
 type Errors []error
 
-// Append appends an error, starting with the given position and
-// formatted according to the format string and the arguments given.
-func (e *Errors) Append(pos lexer.Pos, format string, args ...interface{}) {
-	*e = append(*e, fmt.Errorf(pos.String()+": "+format, args...))
+// Append appends an error with the given code, spanning the source
+// code from pos up to end and formatted according to the format
+// string and the arguments given. It returns the error, such that
+// notes and related positions can be added.
+func (e *Errors) Append(code Code, pos, end lexer.Pos, format string, args ...interface{}) *Diagnostic {
+	d := &Diagnostic{Pos: pos, End: end, Code: code, Msg: fmt.Sprintf(format, args...)}
+	*e = append(*e, d)
+	return d
 }
 
 // Err returns itself or nil, if there are none.
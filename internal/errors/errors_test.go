@@ -19,8 +19,10 @@ func TestErrors(t *testing.T) {
 		t.Errorf("got: %v, expected nil", errs.Err())
 	}
 
-	errs.Append(lexer.Pos{Line: 1, Column: 1}, "error %d", 23)
-	errs.Append(lexer.Pos{Line: 1, Column: 2}, "error %d", 42)
+	f := lexer.NewFile("", []byte("ab"))
+	pos1, pos2 := f.Pos(0), f.Pos(1)
+	errs.Append(errors.UnexpectedToken, pos1, pos1, "error %d", 23)
+	errs.Append(errors.UnexpectedToken, pos2, pos2, "error %d", 42)
 
 	if errs.Err().Error() != errs.Error() {
 		t.Errorf("got:\n%v\nexpected:\n%v", errs.Err(), errs)
@@ -32,7 +34,7 @@ func TestErrors(t *testing.T) {
 	}
 
 	for i := 0; i < 19; i++ {
-		errs.Append(lexer.Pos{Line: uint32(i), Column: uint32(i)}, "error %d", i)
+		errs.Append(errors.UnexpectedToken, pos1, pos1, "error %d", i)
 	}
 
 	lastLine := "and 1 more error"
@@ -42,7 +44,7 @@ func TestErrors(t *testing.T) {
 		t.Errorf("got:\n%v\nexpected:\n%v", actualLastLine, lastLine)
 	}
 
-	errs.Append(lexer.Pos{Line: 1, Column: 1}, "error")
+	errs.Append(errors.UnexpectedToken, pos1, pos1, "error")
 
 	lastLine = "and 2 more errors"
 	errMsg = errs.Error()
@@ -51,3 +53,93 @@ func TestErrors(t *testing.T) {
 		t.Errorf("got:\n%v\nexpected:\n%v", actualLastLine, lastLine)
 	}
 }
+
+func TestPrint(t *testing.T) {
+	const src = "{\n\tlet x := 1;\n\tlet x := \"ä\" + y;\n}\n"
+
+	f := lexer.NewFile("", []byte(src))
+
+	var errs errors.Errors
+	errs.Append(errors.AlreadyDefined, f.LinePos(3, 6), f.LinePos(3, 7), "x already defined").
+		Relate(f.LinePos(2, 6), "previous definition of x")
+	errs.Append(errors.InvalidOp, f.LinePos(3, 11), f.LinePos(3, 19), "cannot apply +").
+		Note("operands must be of the same type")
+	errs.Append(errors.MissingReturn, f.LinePos(4, 1), f.LinePos(4, 1), "missing return")
+	d := errs.Append(errors.UnreachableCode, lexer.Pos{}, lexer.Pos{}, "unreachable code")
+	d.Severity = errors.Warning
+
+	var b strings.Builder
+	errors.Print(&b, errs)
+
+	const expected = `3:6: error[L004]: x already defined
+ 3 | 	let x := "ä" + y;
+   | 	    ^
+2:6: previous definition of x
+ 2 | 	let x := 1;
+   | 	    ^
+3:11: error[L009]: cannot apply +
+ 3 | 	let x := "ä" + y;
+   | 	         ^^^^^^^
+note: operands must be of the same type
+4:1: error[L018]: missing return
+ 4 | }
+   | ^
+-: warning[L020]: unreachable code
+`
+	if b.String() != expected {
+		t.Errorf("got:\n%v\nexpected:\n%v", b.String(), expected)
+	}
+}
+
+func TestPrintCounts(t *testing.T) {
+	f := lexer.NewFile("", []byte("ab"))
+	pos := f.Pos(0)
+
+	var errs errors.Errors
+	for i := 0; i < 22; i++ {
+		errs.Append(errors.UnexpectedToken, pos, pos, "error %d", i)
+	}
+	for i := 0; i < 2; i++ {
+		d := errs.Append(errors.UnreachableCode, pos, pos, "unreachable code")
+		d.Severity = errors.Warning
+	}
+
+	var b strings.Builder
+	errors.Print(&b, errs)
+	const expected = "and 2 more errors\nand 2 more warnings\n"
+	if !strings.HasSuffix(b.String(), expected) {
+		t.Errorf("got:\n%v\nexpected suffix:\n%v", b.String(), expected)
+	}
+
+	errs = errs[2:23]
+	b.Reset()
+	errors.Print(&b, errs)
+	if s := b.String(); !strings.HasSuffix(s, "^\nand 1 more warning\n") {
+		t.Errorf("got:\n%v\nexpected only a warning count", s)
+	}
+}
+
+func TestPrintUnicode(t *testing.T) {
+	const src = "{ let äö ≔ 1 ≤ 2; }"
+	f := lexer.NewFile("", []byte(src))
+	ident := strings.Index(src, "äö")
+	op := strings.Index(src, "1 ≤ 2")
+
+	var errs errors.Errors
+	errs.Append(errors.AlreadyDefined, f.Pos(ident), f.Pos(ident+len("äö")), "äö already defined")
+	errs.Append(errors.InvalidOp, f.Pos(op), f.Pos(op+len("1 ≤ 2")), "cannot apply ≤")
+
+	var b strings.Builder
+	errors.Print(&b, errs)
+
+	const expected = `1:7: error[L004]: äö already defined
+ 1 | { let äö ≔ 1 ≤ 2; }
+   |       ^^
+1:16: error[L009]: cannot apply ≤
+ 1 | { let äö ≔ 1 ≤ 2; }
+   |            ^^^^^
+`
+	if b.String() != expected {
+		t.Errorf("got:\n%v\nexpected:\n%v", b.String(), expected)
+	}
+}
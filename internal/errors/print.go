@@ -0,0 +1,107 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package errors // import "davidrjenni.io/lang/internal/errors"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"davidrjenni.io/lang/lexer"
+)
+
+// Print prints the first 20 errors contained in err. The
+// diagnostics are printed with the excerpts of the source
+// code, they refer to, and with their notes. The remaining
+// errors and warnings are counted separately.
+func Print(w io.Writer, err error) {
+	const n = 20
+
+	errs, ok := err.(Errors)
+	if !ok {
+		errs = Errors{err}
+	}
+	for i, err := range errs {
+		if i == n {
+			break
+		}
+		d, ok := err.(*Diagnostic)
+		if !ok {
+			fmt.Fprintln(w, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s[%s]: %s\n", d.Pos, d.Severity, d.Code, d.Msg)
+		printExcerpt(w, d.Pos, d.End)
+		for _, r := range d.Related {
+			fmt.Fprintf(w, "%s: %s\n", r.Pos, r.Msg)
+			printExcerpt(w, r.Pos, r.Pos)
+		}
+		for _, note := range d.Notes {
+			fmt.Fprintf(w, "note: %s\n", note)
+		}
+	}
+	if len(errs) <= n {
+		return
+	}
+
+	var nerrs, nwarns int
+	for _, err := range errs[n:] {
+		if d, ok := err.(*Diagnostic); ok && d.Severity == Warning {
+			nwarns++
+		} else {
+			nerrs++
+		}
+	}
+	printCount(w, nerrs, "error")
+	printCount(w, nwarns, "warning")
+}
+
+// printCount prints the number of the remaining
+// diagnostics of the kind, if there are any.
+func printCount(w io.Writer, n int, kind string) {
+	switch {
+	case n == 1:
+		fmt.Fprintf(w, "and 1 more %s\n", kind)
+	case n > 1:
+		fmt.Fprintf(w, "and %d more %ss\n", n, kind)
+	}
+}
+
+// printExcerpt prints the line of pos and underlines the source
+// code from pos up to end, or marks pos with a caret, if the
+// source code spans multiple lines.
+func printExcerpt(w io.Writer, pos, end lexer.Pos) {
+	if !pos.IsValid() {
+		return
+	}
+	// The positions may be shifted beyond the end of the file.
+	pos = pos.File().Pos(pos.Offset())
+	if end.IsValid() {
+		end = end.File().Pos(end.Offset())
+	}
+	line := pos.File().Line(pos.Line())
+
+	// The indentation of the caret preserves the tabs,
+	// such that it is aligned with the source code.
+	var indent strings.Builder
+	for _, ch := range string(line[:pos.Column()-1]) {
+		if ch == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+
+	// The caret is as wide as the source code in runes,
+	// e.g. of the identifiers and the Unicode operators.
+	width := 1
+	if end.File() == pos.File() && end.Line() == pos.Line() && end.RuneColumn()-pos.RuneColumn() > 1 {
+		width = end.RuneColumn() - pos.RuneColumn()
+	}
+
+	num := fmt.Sprintf("%d", pos.Line())
+	fmt.Fprintf(w, " %s | %s\n", num, line)
+	fmt.Fprintf(w, " %s | %s%s\n", strings.Repeat(" ", len(num)), indent.String(), strings.Repeat("^", width))
+}
@@ -0,0 +1,510 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp // import "davidrjenni.io/lang/interp"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/types"
+)
+
+// Error is a violation detected at run time, e.g. a violated
+// assertion. Its message is the one of the compiled programs.
+type Error struct {
+	Pos lexer.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Pos.File().Name(), e.Pos.Line(), e.Msg)
+}
+
+// Run executes the type-checked block by walking its tree. The
+// output of print and println is written to out. Run stops at the
+// first violation and returns it as *Error.
+func Run(out io.Writer, b *ast.Block, info types.Info) error {
+	in := &interp{out: bufio.NewWriter(out), info: info}
+	return in.run(func() {
+		in.execCmd(&frame{vars: make(map[*types.Object]*value)}, b)
+	})
+}
+
+// Session executes the inputs of an interactive session, e.g.
+// of a REPL. The variables defined by an input remain defined
+// for the following inputs. The inputs are checked by a
+// types.Session, whose info is passed along with each input.
+type Session struct {
+	in *interp
+	fr *frame
+}
+
+func NewSession(out io.Writer) *Session {
+	return &Session{
+		in: &interp{out: bufio.NewWriter(out)},
+		fr: &frame{vars: make(map[*types.Object]*value)},
+	}
+}
+
+// Exec executes the cmds of the block.
+func (s *Session) Exec(b *ast.Block, info types.Info) error {
+	s.in.info = info
+	return s.in.run(func() { s.in.execCmd(s.fr, b) })
+}
+
+// Eval evaluates the expr and returns its value formatted
+// for display, e.g. strings are quoted. The value of a
+// call of a func without result is empty.
+func (s *Session) Eval(x ast.Expr, info types.Info) (string, error) {
+	s.in.info = info
+	var str string
+	err := s.in.run(func() {
+		if v := s.in.eval(s.fr, x); v != nil {
+			str = inspect(v)
+		}
+	})
+	return str, err
+}
+
+// run calls f, recovers from a violation and flushes the output.
+func (in *interp) run(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+		if ferr := in.out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	f()
+	return nil
+}
+
+// value is the value of an expr: a bool, float64, int64, string,
+// *array, *closure or *union. Arrays and unions are references,
+// like in the compiled programs.
+type value interface{}
+
+type array struct {
+	elems []value
+}
+
+// closure is a func literal with the variables it captures,
+// which are shared with the enclosing funcs.
+type closure struct {
+	lit      *ast.FuncLit
+	captures map[*types.Object]*value
+}
+
+// union is a value of a union type, tagged with its type.
+type union struct {
+	typ types.Type
+	val value
+}
+
+// frame holds the variables of a func call. Captured
+// variables are shared with the frames of closures.
+type frame struct {
+	vars       map[*types.Object]*value
+	resultType types.Type // result type of the func, or nil
+	result     value      // result of the executed return cmd
+}
+
+// ctrl describes how the execution continues after a cmd.
+type ctrl int
+
+const (
+	next ctrl = iota
+	breakLoop
+	continueLoop
+	returnFunc
+)
+
+type interp struct {
+	out  *bufio.Writer
+	info types.Info
+}
+
+func (in *interp) execCmd(fr *frame, cmd ast.Cmd) ctrl {
+	switch cmd := cmd.(type) {
+	case *ast.Assert:
+		if !in.eval(fr, cmd.X).(bool) {
+			panic(&Error{Pos: cmd.Pos(), Msg: "assertion violated"})
+		}
+	case *ast.Assign:
+		if x, ok := cmd.LHS.(*ast.IndexExpr); ok {
+			a, i := in.index(fr, x)
+			a.elems[i] = in.convert(fr, cmd.X, in.info.Types[x].Type)
+			return next
+		}
+		obj := in.info.Uses[cmd.LHS.(*ast.Ident)]
+		*fr.vars[obj] = in.convert(fr, cmd.X, obj.Type)
+	case *ast.Block:
+		for _, c := range cmd.Cmds {
+			if ctrl := in.execCmd(fr, c); ctrl != next {
+				return ctrl
+			}
+		}
+	case *ast.Break:
+		return breakLoop
+	case *ast.Continue:
+		return continueLoop
+	case *ast.ExprCmd:
+		in.eval(fr, cmd.X)
+	case *ast.For:
+		for in.eval(fr, cmd.X).(bool) {
+			switch in.execCmd(fr, cmd.Block) {
+			case breakLoop:
+				return next
+			case returnFunc:
+				return returnFunc
+			}
+		}
+	case *ast.If:
+		if !in.eval(fr, cmd.X).(bool) {
+			if cmd.Else != nil {
+				return in.execCmd(fr, cmd.Else.Cmd)
+			}
+			return next
+		}
+		// The narrowed variables are copies of
+		// the values in the original unions.
+		for _, obj := range in.info.Narrowings[cmd] {
+			u := (*fr.vars[obj.Orig]).(*union)
+			define(fr, obj, u.val)
+		}
+		return in.execCmd(fr, cmd.Block)
+	case *ast.Return:
+		fr.result = in.convert(fr, cmd.X, fr.resultType)
+		return returnFunc
+	case *ast.VarDecl:
+		obj := in.info.Uses[cmd.Ident]
+		define(fr, obj, in.convert(fr, cmd.X, obj.Type))
+	default:
+		panic(fmt.Sprintf("unexpected type %T", cmd))
+	}
+	return next
+}
+
+// define allocates a new variable, such that closures
+// created in a loop capture different variables.
+func define(fr *frame, obj *types.Object, val value) {
+	v := new(value)
+	*v = val
+	fr.vars[obj] = v
+}
+
+// convert evaluates the expr and converts its value to the given
+// type. A value is converted to a union by tagging it with its type.
+func (in *interp) convert(fr *frame, x ast.Expr, typ types.Type) value {
+	val := in.eval(fr, x)
+	from := in.info.Types[x].Type
+	if _, ok := typ.(*types.Union); !ok {
+		return val
+	}
+	if _, ok := from.(*types.Union); ok {
+		return val
+	}
+	return &union{typ: from, val: val}
+}
+
+func (in *interp) eval(fr *frame, x ast.Expr) value {
+	switch x := x.(type) {
+	case *ast.ArrayLit:
+		return in.evalArrayLit(fr, x)
+	case *ast.BinaryExpr:
+		return in.evalBinaryExpr(fr, x)
+	case *ast.Bool:
+		return x.Val == "true"
+	case *ast.CallExpr:
+		return in.evalCallExpr(fr, x)
+	case *ast.F64:
+		val, err := strconv.ParseFloat(x.Val, 64)
+		if err != nil {
+			panic(fmt.Sprintf("cannot convert f64: %v", err))
+		}
+		return val
+	case *ast.FuncLit:
+		c := &closure{lit: x, captures: make(map[*types.Object]*value)}
+		for _, obj := range in.info.Captures[x] {
+			c.captures[obj] = fr.vars[obj]
+		}
+		return c
+	case *ast.I64:
+		val, err := strconv.ParseInt(strings.ReplaceAll(x.Val, "_", ""), 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("cannot convert i64: %v", err))
+		}
+		return val
+	case *ast.Ident:
+		return *fr.vars[in.info.Uses[x]]
+	case *ast.IndexExpr:
+		a, i := in.index(fr, x)
+		return a.elems[i]
+	case *ast.IsExpr:
+		u := in.eval(fr, x.X).(*union)
+		return types.Equal(u.typ, in.info.Tested[x])
+	case *ast.ParenExpr:
+		return in.eval(fr, x.X)
+	case *ast.String:
+		val, err := strconv.Unquote(x.Val)
+		if err != nil {
+			panic(fmt.Sprintf("cannot convert string: %v", err))
+		}
+		return val
+	case *ast.UnaryExpr:
+		switch x.Op {
+		case lexer.Minus:
+			switch v := in.eval(fr, x.X).(type) {
+			case float64:
+				return -v
+			case int64:
+				return -v
+			}
+		case lexer.Not:
+			return !in.eval(fr, x.X).(bool)
+		}
+		panic(fmt.Sprintf("unexpected operator %s", x.Op))
+	default:
+		panic(fmt.Sprintf("unexpected type %T", x))
+	}
+}
+
+// evalArrayLit allocates the array. Missing elems
+// are zeroed, which only bool, f64 and i64 allow.
+func (in *interp) evalArrayLit(fr *frame, l *ast.ArrayLit) value {
+	var (
+		elem types.Type
+		n    int
+	)
+	switch typ := in.info.Types[l].Type.(type) {
+	case *types.Array:
+		elem, n = typ.Elem, int(typ.Len)
+	case *types.Slice:
+		elem, n = typ.Elem, len(l.Elems)
+	}
+
+	a := &array{elems: make([]value, n)}
+	for i, x := range l.Elems {
+		a.elems[i] = in.convert(fr, x, elem)
+	}
+	for i := len(l.Elems); i < n; i++ {
+		switch elem.(type) {
+		case *types.Bool:
+			a.elems[i] = false
+		case *types.F64:
+			a.elems[i] = 0.0
+		case *types.I64:
+			a.elems[i] = int64(0)
+		}
+	}
+	return a
+}
+
+// index evaluates the array and the index and checks,
+// whether the index is in bounds.
+func (in *interp) index(fr *frame, x *ast.IndexExpr) (*array, int64) {
+	a := in.eval(fr, x.X).(*array)
+	i := in.eval(fr, x.Index).(int64)
+	if i < 0 || i >= int64(len(a.elems)) {
+		panic(&Error{Pos: x.Pos(), Msg: "index out of bounds"})
+	}
+	return a, i
+}
+
+func (in *interp) evalBinaryExpr(fr *frame, x *ast.BinaryExpr) value {
+	switch x.Op {
+	case lexer.And:
+		return in.eval(fr, x.LHS).(bool) && in.eval(fr, x.RHS).(bool)
+	case lexer.Or:
+		return in.eval(fr, x.LHS).(bool) || in.eval(fr, x.RHS).(bool)
+	case lexer.Implies:
+		return !in.eval(fr, x.LHS).(bool) || in.eval(fr, x.RHS).(bool)
+	}
+
+	// Evaluate RHS before LHS, like the compiled programs.
+	rhs := in.eval(fr, x.RHS)
+	lhs := in.eval(fr, x.LHS)
+
+	if x.Op == lexer.In {
+		if s, ok := rhs.(string); ok {
+			return strings.Contains(s, lhs.(string))
+		}
+		for _, elem := range rhs.(*array).elems {
+			if elem == lhs {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch l := lhs.(type) {
+	case bool:
+		return compare(x.Op, false, l == rhs.(bool), false)
+	case float64:
+		r := rhs.(float64)
+		switch x.Op {
+		case lexer.Plus:
+			return l + r
+		case lexer.Minus:
+			return l - r
+		case lexer.Multiply:
+			return l * r
+		case lexer.Divide:
+			return l / r
+		}
+		return compare(x.Op, l < r, l == r, l > r)
+	case int64:
+		r := rhs.(int64)
+		switch x.Op {
+		case lexer.Plus:
+			return l + r
+		case lexer.Minus:
+			return l - r
+		case lexer.Multiply:
+			return l * r
+		case lexer.Divide:
+			if r == 0 {
+				panic(&Error{Pos: x.Pos(), Msg: "division by zero"})
+			}
+			return l / r
+		}
+		return compare(x.Op, l < r, l == r, l > r)
+	case string:
+		r := rhs.(string)
+		if x.Op == lexer.Plus {
+			return l + r
+		}
+		return compare(x.Op, l < r, l == r, l > r)
+	}
+	panic(fmt.Sprintf("unexpected operator %s", x.Op))
+}
+
+// compare applies the relational operator to the result of
+// a comparison. For unordered f64 operands, i.e. NaN, none
+// of less, equal and greater is set, which only satisfies ≠.
+func compare(op lexer.Tok, less, equal, greater bool) bool {
+	switch op {
+	case lexer.Equal:
+		return equal
+	case lexer.NotEqual:
+		return !equal
+	case lexer.Less:
+		return less
+	case lexer.LessEq:
+		return less || equal
+	case lexer.Greater:
+		return greater
+	case lexer.GreaterEq:
+		return greater || equal
+	default:
+		panic(fmt.Sprintf("unexpected operator %s", op))
+	}
+}
+
+// evalCallExpr evaluates the args from left to right, followed by
+// the func, and calls it with a new frame. The frame contains the
+// captured variables and the params.
+func (in *interp) evalCallExpr(fr *frame, x *ast.CallExpr) value {
+	if b, ok := in.info.Types[x.Func].Type.(*types.Builtin); ok {
+		return in.evalBuiltinCall(fr, x, b)
+	}
+
+	f := in.info.Types[x.Func].Type.(*types.Func)
+	args := make([]value, 0, len(x.Args))
+	for i, a := range x.Args {
+		args = append(args, in.convert(fr, a, f.Params[i]))
+	}
+	c := in.eval(fr, x.Func).(*closure)
+
+	callee := &frame{
+		vars:       make(map[*types.Object]*value, len(c.captures)+len(args)),
+		resultType: f.Result,
+	}
+	for obj, v := range c.captures {
+		callee.vars[obj] = v
+	}
+	for i, p := range c.lit.Params {
+		define(callee, in.info.Uses[p.Ident], args[i])
+	}
+	in.execCmd(callee, c.lit.Block)
+	return callee.result
+}
+
+// evalBuiltinCall evaluates a call of len, print or println. The
+// args are evaluated first, such that calls in args are completed
+// before anything is printed.
+func (in *interp) evalBuiltinCall(fr *frame, x *ast.CallExpr, b *types.Builtin) value {
+	if b.Name == "len" {
+		return int64(len(in.eval(fr, x.Args[0]).(*array).elems))
+	}
+
+	args := make([]value, 0, len(x.Args))
+	for _, a := range x.Args {
+		args = append(args, in.eval(fr, a))
+	}
+	for i, a := range args {
+		if i > 0 && b.Name == "println" {
+			in.out.WriteByte(' ')
+		}
+		in.out.WriteString(format(a))
+	}
+	if b.Name == "println" {
+		in.out.WriteByte('\n')
+	}
+	return nil
+}
+
+// format formats the value of a scalar type like the runtime of
+// the compiled programs. An f64 is formatted with the fewest
+// digits, which represent it exactly.
+func format(v value) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	default:
+		panic(fmt.Sprintf("unexpected type %T", v))
+	}
+}
+
+// inspect formats any value like a literal, where possible.
+func inspect(v value) string {
+	switch v := v.(type) {
+	case *array:
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, elem := range v.elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(inspect(elem))
+		}
+		b.WriteByte('}')
+		return b.String()
+	case *closure:
+		return "func"
+	case string:
+		return strconv.Quote(v)
+	case *union:
+		return inspect(v.val)
+	default:
+		return format(v)
+	}
+}
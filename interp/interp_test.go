@@ -0,0 +1,98 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidrjenni.io/lang/interp"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRun(t *testing.T) {
+	filename := filepath.Join("test-fixtures", "input.l")
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
+	}
+
+	info, err := types.Check(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var actual bytes.Buffer
+	if err := interp.Run(&actual, b, info); err != nil {
+		t.Fatalf("cannot run: %v", err)
+	}
+
+	golden := filepath.Join("test-fixtures", "input.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, actual.Bytes(), 0644); err != nil {
+			t.Fatalf("cannot update golden file: %v", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("cannot read golden file: %v", err)
+	}
+
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Fatalf("expected\n%s\ngot\n%s\n", string(expected), actual.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := [...]struct {
+		src, out, err string
+	}{
+		{
+			src: "{\n\tprint(1);\n\tassert 1 > 2;\n\tprint(2);\n}",
+			out: "1",
+			err: "a.l:3: assertion violated",
+		},
+		{
+			src: "{\n\tlet a := [2]i64{};\n\tlet i := 2;\n\tprintln(a[i - 3]);\n}",
+			err: "a.l:4: index out of bounds",
+		},
+		{
+			src: "{\n\tlet a := []string{\"a\"};\n\tset a[1] <- \"b\";\n}",
+			err: "a.l:3: index out of bounds",
+		},
+		{
+			src: "{\n\tlet f := func(n i64) i64 {\n\t\treturn 1 / n;\n\t};\n\tprintln(f(1), f(0));\n}",
+			err: "a.l:3: division by zero",
+		},
+	}
+
+	for _, test := range tests {
+		b, _, err := parser.Parse(strings.NewReader(test.src), "a.l")
+		if err != nil {
+			t.Fatalf("cannot parse %q: %v", test.src, err)
+		}
+		info, err := types.Check(b)
+		if err != nil {
+			t.Fatalf("cannot check %q: %v", test.src, err)
+		}
+
+		var out bytes.Buffer
+		err = interp.Run(&out, b, info)
+		if _, ok := err.(*interp.Error); !ok || err.Error() != test.err {
+			t.Errorf("%q: expected error %q, got %v", test.src, test.err, err)
+		}
+		if out.String() != test.out {
+			t.Errorf("%q: expected output %q, got %q", test.src, test.out, out.String())
+		}
+	}
+}
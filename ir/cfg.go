@@ -0,0 +1,256 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir // import "davidrjenni.io/lang/ir"
+
+import "fmt"
+
+// CFG is the control-flow graph of a frame.
+type CFG struct {
+	Name   Label
+	Blocks []*Block // in the order of the frame, starting with the entry
+
+	labels int    // number of labels created for split edges
+	exit   *Block // empty block at the end, if blocks are appended
+}
+
+// Block is a basic block, i.e. a sequence of instructions,
+// which is only entered at the start and only left at the end.
+type Block struct {
+	Index  int
+	Label  Label // label at the start of the block, or ""
+	Phis   []*Phi
+	Instrs Seq
+	Preds  []*Block
+	Succs  []*Block
+
+	// Immediate dominator, or nil for the entry and unreachable blocks.
+	Idom *Block
+}
+
+// NewCFG builds the control-flow graph of the frame. A block starts
+// at a label and ends with a jump or return. The instructions are
+// copied, such that passes over the graph do not modify the frame.
+func NewCFG(f *Frame) *CFG {
+	// The entry block has no label, such that it has no predecessors.
+	blocks := []*Block{{}}
+	b := blocks[0]
+	for _, n := range f.Seq {
+		if l, ok := n.(Label); ok {
+			if b.Index == 0 || b.Label != "" || len(b.Instrs) > 0 {
+				b = &Block{Index: len(blocks)}
+				blocks = append(blocks, b)
+			}
+			b.Label = l
+			continue
+		}
+		b.Instrs = append(b.Instrs, clone(n))
+		switch n.(type) {
+		case *Jump, *CJump, *Return:
+			b = &Block{Index: len(blocks)}
+			blocks = append(blocks, b)
+		}
+	}
+
+	// Drop the empty blocks following jumps and returns.
+	g := &CFG{Name: f.Name}
+	labels := make(map[Label]*Block)
+	for _, b := range blocks {
+		if b.Index > 0 && b.Label == "" && len(b.Instrs) == 0 {
+			continue
+		}
+		b.Index = len(g.Blocks)
+		g.Blocks = append(g.Blocks, b)
+		if b.Label != "" {
+			labels[b.Label] = b
+		}
+	}
+
+	for i, b := range g.Blocks {
+		switch last := b.last().(type) {
+		case *Jump:
+			g.link(b, labels[last.Label])
+			continue
+		case *CJump:
+			g.link(b, labels[last.Label])
+		case *Return:
+			continue
+		}
+		if i+1 < len(g.Blocks) {
+			g.link(b, g.Blocks[i+1])
+		}
+	}
+	return g
+}
+
+func (g *CFG) link(from, to *Block) {
+	for _, s := range from.Succs {
+		if s == to {
+			return
+		}
+	}
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// Frame linearizes the graph into a frame. The graph must not be in SSA form.
+func (g *CFG) Frame() *Frame {
+	f := &Frame{Name: g.Name}
+	for _, b := range g.Blocks {
+		if len(b.Phis) > 0 {
+			panic(fmt.Sprintf("unexpected phis in block %d", b.Index))
+		}
+		if b.Label != "" {
+			f.Seq = append(f.Seq, b.Label)
+		}
+		f.Seq = append(f.Seq, b.Instrs...)
+	}
+	return f
+}
+
+// Dominators computes the immediate dominators of the reachable blocks,
+// following Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm".
+func (g *CFG) Dominators() {
+	order := g.postorder()
+	rpo := make(map[*Block]int, len(order))
+	for i, b := range order {
+		rpo[b] = len(order) - 1 - i
+	}
+	for _, b := range g.Blocks {
+		b.Idom = nil
+	}
+
+	entry := g.Blocks[0]
+	idom := map[*Block]*Block{entry: entry}
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			b := order[i]
+			var dom *Block
+			for _, p := range b.Preds {
+				if _, ok := idom[p]; !ok {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+	for b, dom := range idom {
+		if b != entry {
+			b.Idom = dom
+		}
+	}
+}
+
+// Dominates reports whether b dominates c. Dominators must be computed first.
+func (b *Block) Dominates(c *Block) bool {
+	for ; c != nil; c = c.Idom {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Frontiers returns the dominance frontiers of the reachable blocks, i.e.
+// the blocks, in which the dominance of a block ends. Dominators must be
+// computed first.
+func (g *CFG) Frontiers() map[*Block][]*Block {
+	df := make(map[*Block][]*Block)
+	add := func(b, f *Block) {
+		for _, x := range df[b] {
+			if x == f {
+				return
+			}
+		}
+		df[b] = append(df[b], f)
+	}
+	for _, b := range g.Blocks {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			if !g.reachable(p) {
+				continue
+			}
+			for r := p; r != b.Idom; r = r.Idom {
+				add(r, b)
+			}
+		}
+	}
+	return df
+}
+
+func (g *CFG) reachable(b *Block) bool {
+	return b == g.Blocks[0] || b.Idom != nil
+}
+
+// postorder returns the reachable blocks in postorder.
+func (g *CFG) postorder() []*Block {
+	var order []*Block
+	visited := make(map[*Block]bool)
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b] = true
+		for _, s := range b.Succs {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(g.Blocks[0])
+	return order
+}
+
+// clone returns a shallow copy of the instruction.
+func clone(n Node) Node {
+	switch n := n.(type) {
+	case *BinaryInstr:
+		c := *n
+		return &c
+	case *Call:
+		c := *n
+		return &c
+	case *CJump:
+		c := *n
+		return &c
+	case *Jump:
+		c := *n
+		return &c
+	case *Load:
+		c := *n
+		return &c
+	case *Return:
+		c := *n
+		return &c
+	case *Store:
+		c := *n
+		return &c
+	case *UnaryInstr:
+		c := *n
+		return &c
+	default:
+		panic(fmt.Sprintf("unexpected type %T", n))
+	}
+}
@@ -7,6 +7,7 @@ package ir // import "davidrjenni.io/lang/ir"
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 func Dump(out io.Writer, f *Frame) {
@@ -14,6 +15,38 @@ func Dump(out io.Writer, f *Frame) {
 	d.dump(f)
 }
 
+// DumpCFG dumps the blocks of the graph, each starting with
+// its index, label, predecessors and immediate dominator.
+func DumpCFG(out io.Writer, g *CFG) {
+	d := &dumper{out: out}
+	d.dump(g.Name)
+	for _, b := range g.Blocks {
+		idom := "-"
+		if b.Idom != nil {
+			idom = fmt.Sprintf("b%d", b.Idom.Index)
+		}
+		preds := "-"
+		for i, p := range b.Preds {
+			if i == 0 {
+				preds = ""
+			} else {
+				preds += " "
+			}
+			preds += fmt.Sprintf("b%d", p.Index)
+		}
+		name := fmt.Sprintf("b%d", b.Index)
+		if b.Label != "" {
+			name += " " + string(b.Label)
+		}
+		d.printf("%s  // preds: %s; idom: %s", name, preds, idom)
+		for _, phi := range b.Phis {
+			d.dump(phi)
+		}
+		d.dump(b.Instrs)
+	}
+	d.printf("\n")
+}
+
 type dumper struct {
 	out io.Writer
 }
@@ -27,9 +60,20 @@ func (d *dumper) dump(n Node) {
 			d.dump(seqx.Seq)
 			lhs = seqx.Dst
 		}
+		if n.Src != nil {
+			d.printf("%s %s <- %s %s  // %s", n.Op, lval(n.RHS), lval(n.Src), rval(lhs), n.Pos())
+			return
+		}
 		d.printf("%s %s %s  // %s", n.Op, lval(n.RHS), rval(lhs), n.Pos())
 	case *Call:
-		d.printf("call %s  // %s", n.Label, n.Pos())
+		switch f := n.Func.(type) {
+		case nil:
+			d.printf("call %s  // %s", n.Label, n.Pos())
+		case Label:
+			d.printf("call %s  // %s", f, n.Pos())
+		default:
+			d.printf("call *%s  // %s", rval(f), n.Pos())
+		}
 	case *CJump:
 		d.printf("cjump %s  // %s", n.Label, n.Pos())
 	case *Frame:
@@ -48,6 +92,15 @@ func (d *dumper) dump(n Node) {
 			src = seqx.Dst
 		}
 		d.printf("load %s <- %s  // %s", lval(n.Dst), rval(src), n.Pos())
+	case *Phi:
+		args := make([]string, len(n.Args))
+		for i, a := range n.Args {
+			args[i] = "_"
+			if a != nil {
+				args[i] = lval(a)
+			}
+		}
+		d.printf("phi %s <- %s", lval(n.Dst), strings.Join(args, " "))
 	case *Return:
 		d.printf("return  // %s", n.Pos())
 	case Seq:
@@ -63,6 +116,10 @@ func (d *dumper) dump(n Node) {
 		}
 		d.printf("store.%s %s <- %s  // %s", n.Size, lval(n.Dst), rval(src), n.Pos())
 	case *UnaryInstr:
+		if n.Src != nil {
+			d.printf("%s %s <- %s  // %s", n.Op, lval(n.Reg), lval(n.Src), n.Pos())
+			return
+		}
 		d.printf("%s %s  // %s", n.Op, lval(n.Reg), n.Pos())
 	default:
 		panic(fmt.Sprintf("unexpected type %T", n))
@@ -77,6 +134,10 @@ func rval(n RVal) string {
 		return fmt.Sprintf("f64(%v)", n)
 	case I64:
 		return fmt.Sprintf("i64(%d)", n)
+	case Label:
+		return string(n)
+	case String:
+		return fmt.Sprintf("string(%q)", n)
 	case LVal:
 		return lval(n)
 	default:
@@ -87,13 +148,21 @@ func rval(n RVal) string {
 func lval(n LVal) string {
 	switch n := n.(type) {
 	case *Mem:
+		if n.Index != nil {
+			return fmt.Sprintf("m[%s+%s*%d%+d]", lval(n.Base), lval(n.Index), n.Scale, n.Off)
+		}
+		if n.Base != nil {
+			return fmt.Sprintf("m[%s%+d]", lval(n.Base), n.Off)
+		}
 		return fmt.Sprintf("m[%d]", n.Off)
 	case *Reg:
-		i := 0
-		if n.Second {
-			i = 1
+		if n.Virt > 0 {
+			return fmt.Sprintf("v%s.%d", n.Type, n.Virt)
+		}
+		if n.Arg > 0 {
+			return fmt.Sprintf("a%s.%d", n.Type, n.Arg-1)
 		}
-		return fmt.Sprintf("r%s.%d", n.Type, i)
+		return fmt.Sprintf("r%s", n.Type)
 	default:
 		panic(fmt.Sprintf("unexpected type %T", n))
 	}
@@ -0,0 +1,491 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir // import "davidrjenni.io/lang/ir"
+
+import (
+	"math"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/internal/errors"
+)
+
+// Fold returns a pass, which evaluates the instructions with constant
+// operands, propagates the constants and folds the jumps on constant
+// conditions. Afterwards, the instructions, whose results are unused,
+// are removed. The asserts, which are violated whenever they are
+// reached, are reported as warnings in errs, since the funcs
+// containing them may never be called.
+func Fold(errs *errors.Errors) Pass {
+	return func(seq Seq) Seq {
+		g := NewCFG(&Frame{Seq: seq})
+		in := propagate(g)
+		for _, b := range g.Blocks {
+			if in[b] != nil {
+				fold(g, b, in[b], errs)
+			}
+		}
+		g = NewCFG(g.Frame())
+		for removeUnused(g) {
+		}
+		return g.Frame().Seq
+	}
+}
+
+// consts contains the constant values of the virtual registers and
+// flags at an instruction. A register, which is not defined on any
+// path to the instruction, is missing. A register or the flags are
+// nil, if their values are not constant.
+type consts struct {
+	regs  map[int]RVal
+	flags *flags
+}
+
+// flags contains the operands of the last comparison.
+type flags struct {
+	rhs, lhs RVal
+}
+
+func (c *consts) copy() *consts {
+	regs := make(map[int]RVal, len(c.regs))
+	for v, val := range c.regs {
+		regs[v] = val
+	}
+	return &consts{regs: regs, flags: c.flags}
+}
+
+// meet merges the values of c into d and reports whether d changed.
+func (d *consts) meet(c *consts) bool {
+	changed := false
+	for v, val := range c.regs {
+		old, ok := d.regs[v]
+		switch {
+		case !ok:
+			d.regs[v] = val
+			changed = true
+		case old != nil && !same(old, val):
+			d.regs[v] = nil
+			changed = true
+		}
+	}
+	if d.flags != nil && (c.flags == nil || !same(d.flags.rhs, c.flags.rhs) || !same(d.flags.lhs, c.flags.lhs)) {
+		d.flags = nil
+		changed = true
+	}
+	return changed
+}
+
+// value returns the constant value of v, or nil.
+func (c *consts) value(v RVal) RVal {
+	switch v := v.(type) {
+	case Bool, F64, I64:
+		return v
+	case *Reg:
+		if v.Virt > 0 {
+			return c.regs[v.Virt]
+		}
+	}
+	return nil
+}
+
+// exec updates the values according to the instruction.
+func (c *consts) exec(n Node) {
+	switch n := n.(type) {
+	case *BinaryInstr:
+		if n.Op == Cmp {
+			rhs, lhs := c.value(n.RHS), c.value(n.LHS)
+			c.flags = nil
+			if rhs != nil && lhs != nil {
+				c.flags = &flags{rhs: rhs, lhs: lhs}
+			}
+			return
+		}
+		src := n.Src
+		if src == nil {
+			src = n.RHS
+		}
+		c.set(n.RHS, binary(n.Op, c.value(src), c.value(n.LHS)))
+		c.flags = nil
+	case *Call:
+		c.flags = nil
+	case *Load:
+		c.set(n.Dst, c.value(n.Src))
+	case *UnaryInstr:
+		if n.Op == Neg {
+			src := n.Src
+			if src == nil {
+				src = n.Reg
+			}
+			c.set(n.Reg, neg(c.value(src)))
+			c.flags = nil
+			return
+		}
+		c.set(n.Reg, c.flags.set(n.Op))
+	}
+}
+
+func (c *consts) set(r *Reg, val RVal) {
+	if r.Virt > 0 {
+		c.regs[r.Virt] = val
+	}
+}
+
+// propagate computes the constant values at the start of the blocks,
+// which are reachable, if the jumps on constant conditions are folded.
+func propagate(g *CFG) map[*Block]*consts {
+	in := map[*Block]*consts{g.Blocks[0]: {regs: make(map[int]RVal)}}
+	work := []*Block{g.Blocks[0]}
+	for len(work) > 0 {
+		b := work[0]
+		work = work[1:]
+
+		c := in[b].copy()
+		for _, n := range b.Instrs {
+			c.exec(n)
+		}
+		for _, s := range b.Succs {
+			if !c.takes(b, s) {
+				continue
+			}
+			if in[s] == nil {
+				in[s] = c.copy()
+			} else if !in[s].meet(c) {
+				continue
+			}
+			work = append(work, s)
+		}
+	}
+	return in
+}
+
+// takes reports whether the edge from b to s can be taken.
+func (c *consts) takes(b, s *Block) bool {
+	j, ok := b.last().(*CJump)
+	if !ok || c.flags == nil || len(b.Succs) == 1 {
+		return true
+	}
+	return c.flags.equal() == (s.Label == j.Label)
+}
+
+// fold replaces the operands and instructions, whose values are
+// constant, by the constants and folds the jumps on constant
+// conditions. The block must be reachable.
+func fold(g *CFG, b *Block, in *consts, errs *errors.Errors) {
+	c := in.copy()
+	var instrs Seq
+	for _, n := range b.Instrs {
+		switch n := n.(type) {
+		case *BinaryInstr:
+			if val := c.value(n.LHS); val != nil {
+				n.LHS = val
+			}
+			c.exec(n)
+			if val := c.value(n.RHS); val != nil && n.Op != Cmp && n.RHS.Virt > 0 {
+				instrs = append(instrs, &Load{Src: val, Dst: n.RHS, pos: n.pos})
+				continue
+			}
+		case *CJump:
+			if c.flags != nil {
+				if c.flags.equal() {
+					instrs = append(instrs, &Jump{Label: n.Label, pos: n.pos})
+				} else if n.cond != nil && !isFalse(n.cond) && b.Index+1 < len(g.Blocks) && violates(g.Blocks[b.Index+1]) {
+					d := errs.Append(errors.ViolatedAssert, n.cond.Pos(), n.cond.End(), "assertion is always violated")
+					d.Severity = errors.Warning
+				}
+				continue
+			}
+		case *Load:
+			if val := c.value(n.Src); val != nil {
+				n.Src = val
+			}
+			c.exec(n)
+		case *Store:
+			if val := c.value(n.Src); val != nil {
+				n.Src = val
+			}
+		case *UnaryInstr:
+			c.exec(n)
+			if val := c.value(n.Reg); val != nil && n.Reg.Virt > 0 {
+				instrs = append(instrs, &Load{Src: val, Dst: n.Reg, pos: n.pos})
+				continue
+			}
+		default:
+			c.exec(n)
+		}
+		instrs = append(instrs, n)
+	}
+	b.Instrs = instrs
+}
+
+// isFalse reports whether x is the literal false. An assert
+// of false marks code, which must not be reached, e.g. the
+// end of a func, and is therefore not reported.
+func isFalse(x ast.Expr) bool {
+	for {
+		p, ok := x.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		x = p.X
+	}
+	b, ok := x.(*ast.Bool)
+	return ok && b.Val == "false"
+}
+
+// violates reports whether the block reports a violated assert.
+func violates(b *Block) bool {
+	for _, n := range b.Instrs {
+		if c, ok := n.(*Call); ok && c.Label == assertViolated {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnused removes the instructions without side effects, whose
+// results are not used. It reports whether instructions were removed.
+func removeUnused(g *CFG) bool {
+	live := make(map[*Block]*liveness)
+	for _, b := range g.Blocks {
+		live[b] = &liveness{regs: make(map[int]bool)}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(g.Blocks) - 1; i >= 0; i-- {
+			b := g.Blocks[i]
+			l := &liveness{regs: make(map[int]bool)}
+			for _, s := range b.Succs {
+				l.union(live[s])
+			}
+			for j := len(b.Instrs) - 1; j >= 0; j-- {
+				l.exec(b.Instrs[j])
+			}
+			if live[b].union(l) {
+				changed = true
+			}
+		}
+	}
+
+	removed := false
+	for _, b := range g.Blocks {
+		l := &liveness{regs: make(map[int]bool)}
+		for _, s := range b.Succs {
+			l.union(live[s])
+		}
+		var instrs Seq
+		for j := len(b.Instrs) - 1; j >= 0; j-- {
+			n := b.Instrs[j]
+			if l.unused(n) {
+				removed = true
+				continue
+			}
+			l.exec(n)
+			instrs = append(instrs, n)
+		}
+		for i, j := 0, len(instrs)-1; i < j; i, j = i+1, j-1 {
+			instrs[i], instrs[j] = instrs[j], instrs[i]
+		}
+		b.Instrs = instrs
+	}
+	return removed
+}
+
+// liveness contains the virtual registers and flags, which are live.
+type liveness struct {
+	regs  map[int]bool
+	flags bool
+}
+
+// union adds the registers and flags of m and reports whether l changed.
+func (l *liveness) union(m *liveness) bool {
+	changed := false
+	for v := range m.regs {
+		if !l.regs[v] {
+			l.regs[v] = true
+			changed = true
+		}
+	}
+	if m.flags && !l.flags {
+		l.flags = true
+		changed = true
+	}
+	return changed
+}
+
+// exec updates the liveness before the instruction from the liveness after it.
+func (l *liveness) exec(n Node) {
+	uses, defs := Operands(n)
+	for _, r := range defs {
+		delete(l.regs, r.Virt)
+	}
+	for _, r := range uses {
+		if r.Virt > 0 {
+			l.regs[r.Virt] = true
+		}
+	}
+	switch n := n.(type) {
+	case *BinaryInstr, *Call:
+		l.flags = false
+	case *CJump:
+		l.flags = true
+	case *UnaryInstr:
+		l.flags = n.Op != Neg
+	}
+}
+
+// unused reports whether the instruction has no side effects
+// and its result is not live.
+func (l *liveness) unused(n Node) bool {
+	switch n := n.(type) {
+	case *BinaryInstr:
+		if n.Op == Cmp {
+			return !l.flags
+		}
+		// Divisions by zero must trap.
+		if n.Op == Div && n.RHS.Type == I64Reg {
+			return false
+		}
+		return n.RHS.Virt > 0 && !l.regs[n.RHS.Virt]
+	case *Load:
+		return n.Dst.Virt > 0 && !l.regs[n.Dst.Virt]
+	case *UnaryInstr:
+		return n.Reg.Virt > 0 && !l.regs[n.Reg.Virt]
+	}
+	return false
+}
+
+// binary evaluates the op with constant operands. It returns nil,
+// if an operand is not constant or the op would trap.
+func binary(op Op, rhs, lhs RVal) RVal {
+	switch a := rhs.(type) {
+	case Bool:
+		b, ok := lhs.(Bool)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case And:
+			return a && b
+		case Or:
+			return a || b
+		}
+	case F64:
+		b, ok := lhs.(F64)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case Add:
+			return a + b
+		case Sub:
+			return a - b
+		case Mul:
+			return a * b
+		case Div:
+			return a / b
+		}
+	case I64:
+		b, ok := lhs.(I64)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case Add:
+			return a + b
+		case Sub:
+			return a - b
+		case Mul:
+			return a * b
+		case Div:
+			if b == 0 || (a == math.MinInt64 && b == -1) {
+				return nil
+			}
+			return a / b
+		}
+	}
+	return nil
+}
+
+func neg(v RVal) RVal {
+	switch v := v.(type) {
+	case F64:
+		return -v
+	case I64:
+		return -v
+	}
+	return nil
+}
+
+// set evaluates the set op on the flags. It returns nil, if the
+// flags are not constant.
+func (f *flags) set(op Op) RVal {
+	if f == nil {
+		return nil
+	}
+	switch a := f.rhs.(type) {
+	case Bool:
+		return cmp(op, b2i(a), b2i(f.lhs.(Bool)))
+	case I64:
+		return cmp(op, a, f.lhs.(I64))
+	case F64:
+		b := f.lhs.(F64)
+		unordered := math.IsNaN(float64(a)) || math.IsNaN(float64(b))
+		switch op {
+		case Sete:
+			return Bool(a == b || unordered)
+		case Setne:
+			return Bool(a != b && !unordered)
+		case Seta:
+			return Bool(a > b && !unordered)
+		case Setae:
+			return Bool(a >= b && !unordered)
+		case Setp:
+			return Bool(unordered)
+		case Setnp:
+			return Bool(!unordered)
+		}
+	}
+	return nil
+}
+
+// equal reports whether the flags indicate equal operands, i.e.
+// whether the zero flag is set, which is tested by conditional jumps.
+func (f *flags) equal() bool {
+	return f.set(Sete) == Bool(true)
+}
+
+func cmp(op Op, a, b I64) RVal {
+	switch op {
+	case Setl:
+		return Bool(a < b)
+	case Setle:
+		return Bool(a <= b)
+	case Sete:
+		return Bool(a == b)
+	case Setne:
+		return Bool(a != b)
+	case Setg:
+		return Bool(a > b)
+	case Setge:
+		return Bool(a >= b)
+	}
+	return nil
+}
+
+func b2i(b Bool) I64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// same reports whether the constants are identical, such
+// that 0.0 and -0.0 are distinct and NaNs are identical.
+func same(a, b RVal) bool {
+	if x, ok := a.(F64); ok {
+		y, ok := b.(F64)
+		return ok && math.Float64bits(float64(x)) == math.Float64bits(float64(y))
+	}
+	return a == b
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"davidrjenni.io/lang/internal/errors"
+	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+func TestFold(t *testing.T) {
+	tests := [...]struct {
+		src      string
+		expected string
+		errs     string
+	}{
+		{
+			src: `{ assert 27 = 3 + 5*5 - 1; }`,
+			expected: `main
+jump .L1  // 1:3
+load ai64.2 <- i64(1)  // 1:3
+call AssertViolated  // 1:3
+.L1
+`,
+		},
+		{
+			src: `{ let x := 2 * 3; let y := x * 3; println(y); assert x + y = 24; }`,
+			expected: `main
+load ai64.0 <- i64(18)  // 1:43
+call lang_print_i64  // 1:43
+load ai64.0 <- string("\n")  // 1:35
+call lang_print_string  // 1:35
+jump .L1  // 1:47
+load ai64.2 <- i64(1)  // 1:47
+call AssertViolated  // 1:47
+.L1
+`,
+		},
+		{
+			src: `{ let x := 1; for x < 10 { set x <- x * 2; } println(x); }`,
+			expected: `main
+load vi64.1 <- i64(1)  // 1:3
+.L1
+cmp vi64.1 i64(10)  // 1:19
+setl vbool.3  // 1:19
+cmp vbool.3 bool(false)  // 1:19
+cjump .L2  // 1:15
+load vi64.5 <- vi64.1  // 1:37
+mul vi64.5 i64(2)  // 1:37
+load vi64.1 <- vi64.5  // 1:28
+jump .L1  // 1:15
+.L2
+load ai64.0 <- vi64.1  // 1:54
+call lang_print_i64  // 1:54
+load ai64.0 <- string("\n")  // 1:46
+call lang_print_string  // 1:46
+`,
+		},
+		{
+			src: `{ let x := 1.5 * 2.0; println(x / 0.0, -x); assert x > 2.5; }`,
+			expected: `main
+load af64.0 <- f64(+Inf)  // 1:31
+call lang_print_f64  // 1:31
+load ai64.0 <- string(" ")  // 1:40
+call lang_print_string  // 1:40
+load af64.0 <- f64(-3)  // 1:40
+call lang_print_f64  // 1:40
+load ai64.0 <- string("\n")  // 1:23
+call lang_print_string  // 1:23
+jump .L1  // 1:45
+load ai64.2 <- i64(1)  // 1:45
+call AssertViolated  // 1:45
+.L1
+`,
+		},
+		{
+			src: `{ let x := 2; assert x * 3 = 7; }`,
+			expected: `main
+load ai64.2 <- i64(1)  // 1:15
+call AssertViolated  // 1:15
+.L1
+`,
+			errs: "1:22: assertion is always violated",
+		},
+		{
+			src: `{ assert 1 > 2; }`,
+			expected: `main
+load ai64.2 <- i64(1)  // 1:3
+call AssertViolated  // 1:3
+.L1
+`,
+			errs: "1:10: assertion is always violated",
+		},
+		{
+			src: `{ assert false; }`,
+			expected: `main
+load ai64.2 <- i64(1)  // 1:3
+call AssertViolated  // 1:3
+.L1
+`,
+		},
+		{
+			src: `{ let f := func(n i64) i64 { let x := 2; assert x = 3; return n; }; }`,
+			expected: `main
+load ai64.0 <- i64(8)  // 1:12
+call malloc  // 1:12
+load vi64.2 <- ri64  // 1:12
+store.i64 m[vi64.2+0] <- func1  // 1:12
+`,
+			errs: "1:49: assertion is always violated",
+		},
+		{
+			src: `{ if false { assert false; } else { println(true ∨ false); } }`,
+			expected: `main
+jump .L1  // 1:3
+load vbool.2 <- bool(false)  // 1:21
+cmp vbool.2 bool(true)  // 1:21
+cjump .L2  // 1:14
+load ai64.2 <- i64(1)  // 1:14
+call AssertViolated  // 1:14
+.L2
+jump .L3  // 1:3
+.L1
+jump .L4  // 1:45
+.L4
+load abool.0 <- bool(true)  // 1:45
+call lang_print_bool  // 1:45
+load ai64.0 <- string("\n")  // 1:37
+call lang_print_string  // 1:37
+.L3
+`,
+		},
+	}
+
+	for _, test := range tests {
+		b, _, err := parser.Parse(strings.NewReader(test.src), "")
+		if err != nil {
+			t.Fatalf("cannot parse: %v", err)
+		}
+
+		info, err := types.Check(b)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		var errs errors.Errors
+		var actual bytes.Buffer
+		ir.Dump(&actual, ir.Translate(b, info, ir.Fold(&errs))[0])
+		if actual.String() != test.expected+"\n\n" {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.src, test.expected, actual.String())
+		}
+		if err := errs.Err(); err != nil && err.Error() != test.errs || err == nil && test.errs != "" {
+			t.Errorf("%s: expected errors %q, got %v", test.src, test.errs, err)
+		}
+		for _, err := range errs {
+			if d := err.(*errors.Diagnostic); d.Severity != errors.Warning {
+				t.Errorf("%s: expected warning, got %s", test.src, d.Severity)
+			}
+		}
+	}
+}
@@ -7,7 +7,9 @@ package ir // import "davidrjenni.io/lang/ir"
 import (
 	"fmt"
 
+	"davidrjenni.io/lang/ast"
 	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/types"
 )
 
 //go:generate stringer -type=Op -linecomment
@@ -19,9 +21,8 @@ type (
 	}
 
 	Frame struct {
-		Name  Label
-		Seq   Seq
-		Stack int
+		Name Label
+		Seq  Seq
 	}
 
 	Label string
@@ -40,21 +41,27 @@ type (
 		Node
 	}
 
+	// BinaryInstr applies the op to RHS and LHS and stores the
+	// result in RHS. Cmp only sets the flags. In SSA form, the
+	// register operand is Src, such that RHS is only defined.
 	BinaryInstr struct {
 		RHS *Reg
 		Op  Op
 		LHS RVal
+		Src *Reg // register operand, if it is not RHS, or nil
 		pos lexer.Pos
 	}
 
 	Call struct {
-		Label Label
+		Label Label // macro to expand, if Func is nil
+		Func  RVal  // address of the called frame
 		pos   lexer.Pos
 	}
 
 	CJump struct {
 		Label Label
 		pos   lexer.Pos
+		cond  ast.Expr // condition of the assert, which is checked, or nil
 	}
 
 	Jump struct {
@@ -68,6 +75,13 @@ type (
 		pos lexer.Pos
 	}
 
+	// Phi defines Dst as the arg of the predecessor, from
+	// which its block is entered. Phis only occur in SSA form.
+	Phi struct {
+		Dst  *Reg
+		Args []*Reg // args by predecessor, or nil, if undefined
+	}
+
 	Return struct {
 		pos lexer.Pos
 	}
@@ -79,9 +93,13 @@ type (
 		pos  lexer.Pos
 	}
 
+	// UnaryInstr stores the result of the op in Reg. Neg
+	// negates Reg, or Src in SSA form, and the set ops set
+	// Reg according to the flags.
 	UnaryInstr struct {
 		Reg *Reg
 		Op  Op
+		Src *Reg // operand of Neg, if it is not Reg, or nil
 		pos lexer.Pos
 	}
 )
@@ -100,6 +118,7 @@ func (*Call) node()        {}
 func (*CJump) node()       {}
 func (*Jump) node()        {}
 func (*Load) node()        {}
+func (*Phi) node()         {}
 func (*Return) node()      {}
 func (*Store) node()       {}
 func (*UnaryInstr) node()  {}
@@ -125,6 +144,8 @@ type (
 
 	I64 int64
 
+	String string
+
 	seqExpr struct {
 		Seq Seq
 		Dst *Reg
@@ -134,11 +155,14 @@ type (
 func (Bool) node()     {}
 func (F64) node()      {}
 func (I64) node()      {}
+func (String) node()   {}
 func (*seqExpr) node() {}
 
+func (Label) rval()    {}
 func (Bool) rval()     {}
 func (F64) rval()      {}
 func (I64) rval()      {}
+func (String) rval()   {}
 func (*seqExpr) rval() {}
 
 type (
@@ -148,12 +172,19 @@ type (
 	}
 
 	Mem struct {
-		Off int
+		Base  *Reg // base register, or the frame pointer if nil
+		Index *Reg // index register scaled by Scale, or nil
+		Scale int
+		Off   int
 	}
 
+	// Reg is a virtual register, if Virt is not 0, an
+	// argument register, if Arg is not 0, and the register
+	// holding the results of calls otherwise.
 	Reg struct {
-		Type   RegType
-		Second bool
+		Type RegType
+		Virt int // virtual register number, starting at 1, or 0
+		Arg  int // argument register number, starting at 1, or 0
 	}
 )
 
@@ -169,10 +200,7 @@ func (*Reg) lval() {}
 type Op int
 
 const (
-	Push Op = iota // push
-	Pop            // pop
-
-	Neg // neg
+	Neg Op = iota // neg
 
 	Add // add
 	Sub // sub
@@ -189,6 +217,12 @@ const (
 	Setne // setne
 	Setg  // setg
 	Setge // setge
+
+	// Comparisons of f64 values, see f64CmpOps.
+	Seta  // seta
+	Setae // setae
+	Setp  // setp
+	Setnp // setnp
 )
 
 var (
@@ -198,10 +232,6 @@ var (
 		lexer.Multiply: Mul,
 		lexer.Divide:   Div,
 
-		lexer.And:     And,
-		lexer.Or:      Or,
-		lexer.Implies: Or, // Assumes desugaring of a => b into ¬a ∨ b.
-
 		lexer.Less:      Cmp,
 		lexer.LessEq:    Cmp,
 		lexer.Equal:     Cmp,
@@ -218,6 +248,14 @@ var (
 		lexer.Greater:   Setg,
 		lexer.GreaterEq: Setge,
 	}
+
+	// f64CmpOps assumes that the operands of < and ≤ are swapped.
+	f64CmpOps = map[lexer.Tok]Op{
+		lexer.Less:      Seta,
+		lexer.LessEq:    Setae,
+		lexer.Greater:   Seta,
+		lexer.GreaterEq: Setae,
+	}
 )
 
 func binOp(op lexer.Tok) Op {
@@ -248,13 +286,77 @@ const (
 	I64Reg                 // i64
 )
 
-func regType(sz int) RegType {
-	switch sz {
-	case 1:
+func regType(t types.Type) RegType {
+	switch t.(type) {
+	case *types.Bool:
 		return BoolReg
-	case 8:
+	case *types.F64:
+		return F64Reg
+	default:
+		if sz := t.Size(); sz != 8 {
+			panic(fmt.Sprintf("unexpected size %d of type %s", sz, t))
+		}
 		return I64Reg
+	}
+}
+
+// Operands returns the registers read and written by the instruction.
+func Operands(n Node) (uses, defs []*Reg) {
+	switch n := n.(type) {
+	case *BinaryInstr:
+		uses = regs(n.LHS)
+		switch {
+		case n.Op == Cmp:
+			uses = append(uses, n.RHS)
+		case n.Src != nil:
+			uses = append(uses, n.Src)
+			defs = []*Reg{n.RHS}
+		default:
+			uses = append(uses, n.RHS)
+			defs = []*Reg{n.RHS}
+		}
+	case *Call:
+		uses = regs(n.Func)
+	case *Load:
+		uses = regs(n.Src)
+		defs = []*Reg{n.Dst}
+	case *Phi:
+		for _, a := range n.Args {
+			if a != nil {
+				uses = append(uses, a)
+			}
+		}
+		defs = []*Reg{n.Dst}
+	case *Store:
+		uses = append(regs(n.Src), regs(n.Dst)...)
+	case *UnaryInstr:
+		switch {
+		case n.Op != Neg:
+		case n.Src != nil:
+			uses = []*Reg{n.Src}
+		default:
+			uses = []*Reg{n.Reg}
+		}
+		defs = []*Reg{n.Reg}
+	}
+	return uses, defs
+}
+
+// regs returns the registers of the value.
+func regs(v RVal) []*Reg {
+	switch v := v.(type) {
+	case *Reg:
+		return []*Reg{v}
+	case *Mem:
+		var regs []*Reg
+		if v.Base != nil {
+			regs = append(regs, v.Base)
+		}
+		if v.Index != nil {
+			regs = append(regs, v.Index)
+		}
+		return regs
 	default:
-		panic(fmt.Sprintf("unexpected size %d", sz))
+		return nil
 	}
 }
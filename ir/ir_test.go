@@ -17,11 +17,13 @@ var (
 	_ ir.Node = &ir.Jump{}
 	_ ir.Node = ir.Label("")
 	_ ir.Node = &ir.Load{}
+	_ ir.Node = &ir.Phi{}
 	_ ir.Node = &ir.Return{}
 	_ ir.Node = &ir.Mem{}
 	_ ir.Node = &ir.Reg{}
 	_ ir.Node = ir.Seq{}
 	_ ir.Node = &ir.Store{}
+	_ ir.Node = ir.String("")
 	_ ir.Node = &ir.UnaryInstr{}
 
 	_ ir.Cmd = &ir.BinaryInstr{}
@@ -36,6 +38,8 @@ var (
 	_ ir.RVal = ir.Bool(false)
 	_ ir.RVal = ir.F64(0)
 	_ ir.RVal = ir.I64(0)
+	_ ir.RVal = ir.Label("")
+	_ ir.RVal = ir.String("")
 	_ ir.RVal = &ir.Mem{}
 	_ ir.RVal = &ir.Reg{}
 
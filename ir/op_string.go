@@ -8,27 +8,29 @@ func _() {
 	// An "invalid array index" compiler error signifies that the constant values have changed.
 	// Re-run the stringer command to generate them again.
 	var x [1]struct{}
-	_ = x[Push-0]
-	_ = x[Pop-1]
-	_ = x[Neg-2]
-	_ = x[Add-3]
-	_ = x[Sub-4]
-	_ = x[Mul-5]
-	_ = x[Div-6]
-	_ = x[Cmp-7]
-	_ = x[And-8]
-	_ = x[Or-9]
-	_ = x[Setl-10]
-	_ = x[Setle-11]
-	_ = x[Sete-12]
-	_ = x[Setne-13]
-	_ = x[Setg-14]
-	_ = x[Setge-15]
+	_ = x[Neg-0]
+	_ = x[Add-1]
+	_ = x[Sub-2]
+	_ = x[Mul-3]
+	_ = x[Div-4]
+	_ = x[Cmp-5]
+	_ = x[And-6]
+	_ = x[Or-7]
+	_ = x[Setl-8]
+	_ = x[Setle-9]
+	_ = x[Sete-10]
+	_ = x[Setne-11]
+	_ = x[Setg-12]
+	_ = x[Setge-13]
+	_ = x[Seta-14]
+	_ = x[Setae-15]
+	_ = x[Setp-16]
+	_ = x[Setnp-17]
 }
 
-const _Op_name = "pushpopnegaddsubmuldivcmpandorsetlsetlesetesetnesetgsetge"
+const _Op_name = "negaddsubmuldivcmpandorsetlsetlesetesetnesetgsetgesetasetaesetpsetnp"
 
-var _Op_index = [...]uint8{0, 4, 7, 10, 13, 16, 19, 22, 25, 28, 30, 34, 39, 43, 48, 52, 57}
+var _Op_index = [...]uint8{0, 3, 6, 9, 12, 15, 18, 21, 23, 27, 32, 36, 41, 45, 50, 54, 59, 63, 68}
 
 func (i Op) String() string {
 	if i < 0 || i >= Op(len(_Op_index)-1) {
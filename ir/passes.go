@@ -6,7 +6,10 @@ package ir // import "davidrjenni.io/lang/ir"
 
 type Pass func(Seq) Seq
 
-var Loads = Pass(loads)
+var (
+	Loads       = Pass(loads)
+	Unreachable = Pass(unreachable)
+)
 
 func flatten(seq Seq) (tseq Seq) {
 	for _, n := range seq {
@@ -60,7 +63,7 @@ func loads(seq Seq) (tseq Seq) {
 	for _, n := range seq {
 		if n, ok := n.(*Load); ok {
 			src, ok := n.Src.(*Reg)
-			if ok && src.Type == n.Dst.Type && src.Second == n.Dst.Second {
+			if ok && *src == *n.Dst {
 				continue
 			}
 		}
@@ -68,3 +71,42 @@ func loads(seq Seq) (tseq Seq) {
 	}
 	return tseq
 }
+
+// unreachable removes the instructions, which are not reachable from
+// the start of the frame, the jumps to the immediately following
+// labels and the labels, which are not jumped to.
+func unreachable(seq Seq) Seq {
+	g := NewCFG(&Frame{Seq: seq})
+	reachable := make(map[*Block]bool)
+	for _, b := range g.postorder() {
+		reachable[b] = true
+	}
+
+	var blocks []*Block
+	for _, b := range g.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	targets := make(map[Label]bool)
+	for i, b := range blocks {
+		if j, ok := b.last().(*Jump); ok && i+1 < len(blocks) && blocks[i+1].Label == j.Label {
+			b.Instrs = b.Instrs[:len(b.Instrs)-1]
+		}
+		switch j := b.last().(type) {
+		case *CJump:
+			targets[j.Label] = true
+		case *Jump:
+			targets[j.Label] = true
+		}
+	}
+
+	var tseq Seq
+	for _, b := range blocks {
+		if targets[b.Label] {
+			tseq = append(tseq, b.Label)
+		}
+		tseq = append(tseq, b.Instrs...)
+	}
+	return tseq
+}
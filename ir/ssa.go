@@ -0,0 +1,444 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir // import "davidrjenni.io/lang/ir"
+
+import (
+	"fmt"
+
+	"davidrjenni.io/lang/lexer"
+)
+
+// ToSSA converts the graph into SSA form, such that every virtual
+// register is defined exactly once. Unreachable blocks are removed,
+// and phis are only placed for registers, which are live.
+func (g *CFG) ToSSA() {
+	g.removeUnreachable()
+	g.Dominators()
+
+	live := g.liveIns()
+	df := g.Frontiers()
+
+	// Place the phis at the iterated dominance frontiers of the defs.
+	defsites := make(map[int][]*Block)
+	types := make(map[int]RegType)
+	for _, b := range g.Blocks {
+		for _, n := range b.Instrs {
+			_, defs := Operands(n)
+			for _, r := range defs {
+				if r.Virt > 0 {
+					defsites[r.Virt] = append(defsites[r.Virt], b)
+					types[r.Virt] = r.Type
+				}
+			}
+		}
+	}
+	max := g.maxVirt()
+	for v := 1; v <= max; v++ {
+		work := defsites[v]
+		placed := make(map[*Block]bool)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, f := range df[b] {
+				if placed[f] || !live[f][v] {
+					continue
+				}
+				placed[f] = true
+				f.Phis = append(f.Phis, &Phi{
+					Dst:  &Reg{Type: types[v], Virt: v},
+					Args: make([]*Reg, len(f.Preds)),
+				})
+				work = append(work, f)
+			}
+		}
+	}
+
+	r := &renamer{
+		next:    max,
+		stacks:  make(map[int][]*Reg),
+		defined: make(map[int]bool),
+		orig:    make(map[*Phi]int),
+	}
+	children := make(map[*Block][]*Block)
+	for _, b := range g.Blocks {
+		if b.Idom != nil {
+			children[b.Idom] = append(children[b.Idom], b)
+		}
+	}
+	var rename func(b *Block)
+	rename = func(b *Block) {
+		var pushed []int
+		for _, phi := range b.Phis {
+			v := phi.Dst.Virt
+			r.orig[phi] = v
+			phi.Dst = r.def(phi.Dst)
+			pushed = append(pushed, v)
+		}
+		for i, n := range b.Instrs {
+			var defs []int
+			b.Instrs[i], defs = r.instr(n)
+			pushed = append(pushed, defs...)
+		}
+		for _, s := range b.Succs {
+			j := predIndex(s, b)
+			for _, phi := range s.Phis {
+				v, ok := r.orig[phi]
+				if !ok {
+					v = phi.Dst.Virt // not renamed yet
+				}
+				phi.Args[j] = r.top(v)
+			}
+		}
+		for _, c := range children[b] {
+			rename(c)
+		}
+		for _, v := range pushed {
+			r.stacks[v] = r.stacks[v][:len(r.stacks[v])-1]
+		}
+	}
+	rename(g.Blocks[0])
+}
+
+// renamer renames the virtual registers during the conversion into SSA form.
+type renamer struct {
+	next    int            // last virtual register number
+	stacks  map[int][]*Reg // current names of the original registers
+	defined map[int]bool   // original registers, which are defined
+	orig    map[*Phi]int   // original registers of the renamed phis
+}
+
+// def returns a new name of the register and pushes it. The first
+// def of a register keeps its number.
+func (r *renamer) def(reg *Reg) *Reg {
+	v := reg.Virt
+	name := &Reg{Type: reg.Type, Virt: v}
+	if r.defined[v] {
+		r.next++
+		name.Virt = r.next
+	}
+	r.defined[v] = true
+	r.stacks[v] = append(r.stacks[v], name)
+	return name
+}
+
+// top returns the current name of the register, or nil if it is undefined.
+func (r *renamer) top(v int) *Reg {
+	s := r.stacks[v]
+	if len(s) == 0 {
+		return nil
+	}
+	return s[len(s)-1]
+}
+
+// use returns the current name of the register. Registers, which are
+// not virtual or undefined, are left as they are.
+func (r *renamer) use(reg *Reg) *Reg {
+	if reg == nil || reg.Virt == 0 {
+		return reg
+	}
+	if name := r.top(reg.Virt); name != nil {
+		return name
+	}
+	return reg
+}
+
+func (r *renamer) rval(v RVal) RVal {
+	switch v := v.(type) {
+	case *Reg:
+		return r.use(v)
+	case *Mem:
+		m := *v
+		m.Base, m.Index = r.use(v.Base), r.use(v.Index)
+		return &m
+	default:
+		return v
+	}
+}
+
+// instr renames the operands of the instruction and returns the
+// original numbers of the defined virtual registers.
+func (r *renamer) instr(n Node) (Node, []int) {
+	switch n := n.(type) {
+	case *BinaryInstr:
+		n.LHS = r.rval(n.LHS)
+		if n.Op == Cmp {
+			n.RHS = r.use(n.RHS)
+			return n, nil
+		}
+		if n.Src == nil {
+			n.Src = r.use(n.RHS)
+		} else {
+			n.Src = r.use(n.Src)
+		}
+		return n, r.define(&n.RHS)
+	case *Call:
+		n.Func = r.rval(n.Func)
+	case *Load:
+		n.Src = r.rval(n.Src)
+		return n, r.define(&n.Dst)
+	case *Store:
+		n.Src = r.rval(n.Src)
+		n.Dst = r.rval(n.Dst).(*Mem)
+	case *UnaryInstr:
+		if n.Op == Neg {
+			if n.Src == nil {
+				n.Src = r.use(n.Reg)
+			} else {
+				n.Src = r.use(n.Src)
+			}
+		}
+		return n, r.define(&n.Reg)
+	}
+	return n, nil
+}
+
+func (r *renamer) define(reg **Reg) []int {
+	if (*reg).Virt == 0 {
+		return nil
+	}
+	v := (*reg).Virt
+	*reg = r.def(*reg)
+	return []int{v}
+}
+
+// FromSSA converts the graph out of SSA form. The phis are replaced
+// by copies at the end of the predecessors, for which critical edges
+// are split, and the register operands are tied to the results.
+func (g *CFG) FromSSA() {
+	next := g.maxVirt()
+	newReg := func(t RegType) *Reg {
+		next++
+		return &Reg{Type: t, Virt: next}
+	}
+
+	for _, b := range append([]*Block(nil), g.Blocks...) {
+		if len(b.Phis) == 0 {
+			continue
+		}
+		for j, p := range append([]*Block(nil), b.Preds...) {
+			pos := p.pos()
+			if len(p.Succs) > 1 {
+				p = g.split(p, b)
+			}
+
+			// The phis are evaluated in parallel, hence
+			// the args are copied into temporaries first.
+			var copies, moves Seq
+			for _, phi := range b.Phis {
+				if phi.Args[j] == nil {
+					continue
+				}
+				tmp := newReg(phi.Dst.Type)
+				copies = append(copies, &Load{Src: phi.Args[j], Dst: tmp, pos: pos})
+				moves = append(moves, &Load{Src: tmp, Dst: phi.Dst, pos: pos})
+			}
+			p.insert(append(copies, moves...))
+		}
+		b.Phis = nil
+	}
+
+	for _, b := range g.Blocks {
+		var instrs Seq
+		for _, n := range b.Instrs {
+			switch n := n.(type) {
+			case *BinaryInstr:
+				if n.Src != nil {
+					instrs = append(instrs, &Load{Src: n.Src, Dst: n.RHS, pos: n.pos})
+					n.Src = nil
+				}
+			case *UnaryInstr:
+				if n.Src != nil {
+					instrs = append(instrs, &Load{Src: n.Src, Dst: n.Reg, pos: n.pos})
+					n.Src = nil
+				}
+			}
+			instrs = append(instrs, n)
+		}
+		b.Instrs = instrs
+	}
+}
+
+// split inserts an empty block on the edge from p to b and returns it.
+func (g *CFG) split(p, b *Block) *Block {
+	g.labels++
+	s := &Block{
+		Label: Label(fmt.Sprintf(".L%s.%d", g.Name, g.labels)),
+		Preds: []*Block{p},
+		Succs: []*Block{b},
+	}
+	if j, ok := p.last().(*CJump); ok && j.Label == b.Label {
+		// The block is placed at the end and jumps back.
+		p.Instrs[len(p.Instrs)-1] = &CJump{Label: s.Label, pos: j.pos, cond: j.cond}
+		s.Instrs = Seq{&Jump{Label: b.Label, pos: j.pos}}
+		i := len(g.Blocks)
+		if g.exit == nil {
+			// If the frame ends by falling through, it must
+			// jump over the blocks appended to the end.
+			end := g.Blocks[i-1]
+			if _, ok := end.last().(*Return); !ok && len(end.Succs) == 0 {
+				g.labels++
+				g.exit = &Block{Label: Label(fmt.Sprintf(".L%s.%d", g.Name, g.labels))}
+				end.Instrs = append(end.Instrs, &Jump{Label: g.exit.Label, pos: end.pos()})
+				g.link(end, g.exit)
+				g.Blocks = append(g.Blocks, g.exit)
+				i++
+			}
+		}
+		if g.exit != nil {
+			i--
+		}
+		g.Blocks = append(g.Blocks[:i], append([]*Block{s}, g.Blocks[i:]...)...)
+	} else {
+		// The block is placed between p and b and falls through.
+		i := p.Index + 1
+		g.Blocks = append(g.Blocks[:i], append([]*Block{s}, g.Blocks[i:]...)...)
+	}
+	for i, x := range g.Blocks {
+		x.Index = i
+	}
+	for i, x := range p.Succs {
+		if x == b {
+			p.Succs[i] = s
+		}
+	}
+	for i, x := range b.Preds {
+		if x == p {
+			b.Preds[i] = s
+		}
+	}
+	return s
+}
+
+// insert inserts the instructions before the jump or
+// return at the end of the block, if there is one.
+func (b *Block) insert(seq Seq) {
+	i := len(b.Instrs)
+	switch b.last().(type) {
+	case *Jump, *CJump, *Return:
+		i--
+	}
+	b.Instrs = append(b.Instrs[:i], append(seq, b.Instrs[i:]...)...)
+}
+
+// last returns the last instruction of the block, or nil.
+func (b *Block) last() Node {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	return b.Instrs[len(b.Instrs)-1]
+}
+
+// pos returns the position of the last instruction of the block.
+func (b *Block) pos() lexer.Pos {
+	if n := b.last(); n != nil {
+		return n.(Cmd).Pos()
+	}
+	return lexer.Pos{}
+}
+
+// removeUnreachable removes the blocks, which are not
+// reachable from the entry, and their outgoing edges.
+func (g *CFG) removeUnreachable() {
+	reachable := make(map[*Block]bool)
+	for _, b := range g.postorder() {
+		reachable[b] = true
+	}
+	var blocks []*Block
+	for _, b := range g.Blocks {
+		if !reachable[b] {
+			continue
+		}
+		var preds []*Block
+		for _, p := range b.Preds {
+			if reachable[p] {
+				preds = append(preds, p)
+			}
+		}
+		b.Preds = preds
+		b.Index = len(blocks)
+		blocks = append(blocks, b)
+	}
+	g.Blocks = blocks
+}
+
+// liveIns returns the virtual registers, which are live at the start
+// of the blocks, ignoring phis.
+func (g *CFG) liveIns() map[*Block]map[int]bool {
+	uses := make(map[*Block]map[int]bool)
+	defs := make(map[*Block]map[int]bool)
+	for _, b := range g.Blocks {
+		uses[b], defs[b] = make(map[int]bool), make(map[int]bool)
+		for _, n := range b.Instrs {
+			u, d := Operands(n)
+			for _, r := range u {
+				if r.Virt > 0 && !defs[b][r.Virt] {
+					uses[b][r.Virt] = true
+				}
+			}
+			for _, r := range d {
+				if r.Virt > 0 {
+					defs[b][r.Virt] = true
+				}
+			}
+		}
+	}
+
+	live := make(map[*Block]map[int]bool)
+	for _, b := range g.Blocks {
+		live[b] = make(map[int]bool)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(g.Blocks) - 1; i >= 0; i-- {
+			b := g.Blocks[i]
+			in := make(map[int]bool)
+			for v := range uses[b] {
+				in[v] = true
+			}
+			for _, s := range b.Succs {
+				for v := range live[s] {
+					if !defs[b][v] {
+						in[v] = true
+					}
+				}
+			}
+			if len(in) != len(live[b]) {
+				live[b] = in
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+// maxVirt returns the highest virtual register number in the graph.
+func (g *CFG) maxVirt() int {
+	max := 0
+	for _, b := range g.Blocks {
+		for _, phi := range b.Phis {
+			if phi.Dst.Virt > max {
+				max = phi.Dst.Virt
+			}
+		}
+		for _, n := range b.Instrs {
+			uses, defs := Operands(n)
+			for _, r := range append(uses, defs...) {
+				if r.Virt > max {
+					max = r.Virt
+				}
+			}
+		}
+	}
+	return max
+}
+
+func predIndex(b, p *Block) int {
+	for i, x := range b.Preds {
+		if x == p {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("block %d is no predecessor of block %d", p.Index, b.Index))
+}
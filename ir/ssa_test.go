@@ -0,0 +1,58 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+func TestSSA(t *testing.T) {
+	filename := filepath.Join("test-fixtures", "input.l")
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
+	}
+
+	info, err := types.Check(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var ssa, out bytes.Buffer
+	for _, f := range ir.Translate(b, info) {
+		g := ir.NewCFG(f)
+		g.ToSSA()
+		ir.DumpCFG(&ssa, g)
+
+		defined := make(map[int]bool)
+		for _, b := range g.Blocks {
+			for _, phi := range b.Phis {
+				if len(phi.Args) != len(b.Preds) {
+					t.Errorf("%s: phi of %d args in block %d with %d preds", f.Name, len(phi.Args), b.Index, len(b.Preds))
+				}
+			}
+			for _, n := range b.Instrs {
+				_, defs := ir.Operands(n)
+				for _, r := range defs {
+					if r.Virt > 0 && defined[r.Virt] {
+						t.Errorf("%s: register %d is defined twice", f.Name, r.Virt)
+					}
+					defined[r.Virt] = true
+				}
+			}
+		}
+
+		g.FromSSA()
+		ir.Dump(&out, g.Frame())
+	}
+	cmpGolden(t, ssa.Bytes(), "input.ssa.golden", *update)
+	cmpGolden(t, out.Bytes(), "input.fromssa.golden", *update)
+}
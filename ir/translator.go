@@ -7,6 +7,7 @@ package ir // import "davidrjenni.io/lang/ir"
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"davidrjenni.io/lang/ast"
 	"davidrjenni.io/lang/lexer"
@@ -15,6 +16,23 @@ import (
 
 const (
 	assertViolated = Label("AssertViolated")
+	boundsViolated = Label("BoundsViolated")
+	divisionByZero = Label("DivisionByZero")
+	calloc         = Label("calloc")
+	malloc         = Label("malloc")
+
+	// Runtime funcs, see compiler.Runtime.
+	langConcat      = Label("lang_concat")
+	langStrcmp      = Label("lang_strcmp")
+	langSubstring   = Label("lang_substring")
+	langElemBool    = Label("lang_elem_bool")
+	langElemF64     = Label("lang_elem_f64")
+	langElemI64     = Label("lang_elem_i64")
+	langElemString  = Label("lang_elem_string")
+	langPrintBool   = Label("lang_print_bool")
+	langPrintF64    = Label("lang_print_f64")
+	langPrintI64    = Label("lang_print_i64")
+	langPrintString = Label("lang_print_string")
 )
 
 const (
@@ -22,21 +40,21 @@ const (
 	false_ = Bool(false)
 )
 
-var (
-	i64Reg1  = &Reg{Type: I64Reg, Second: false}
-	i64Reg2  = &Reg{Type: I64Reg, Second: true}
-	boolReg1 = &Reg{Type: BoolReg, Second: false}
-	boolReg2 = &Reg{Type: BoolReg, Second: true}
-	f64Reg1  = &Reg{Type: F64Reg, Second: false}
-	f64Reg2  = &Reg{Type: F64Reg, Second: true}
-)
+// lineReg passes the line of a violation to the macros.
+var lineReg = &Reg{Type: I64Reg, Arg: 3}
 
 func Translate(b *ast.Block, info types.Info, passes ...Pass) []*Frame {
 	t := &translator{
 		info:   info,
 		passes: passes,
+		boxed:  make(map[*types.Object]bool),
+	}
+	for _, objs := range info.Captures {
+		for _, obj := range objs {
+			t.boxed[obj] = true
+		}
 	}
-	t.translateFrame(b, Label("main"))
+	t.translateFrame(Label("main"), nil, nil, nil, b)
 	return t.frames
 }
 
@@ -44,7 +62,13 @@ type translator struct {
 	info   types.Info
 	passes []Pass
 
+	// boxed contains the captured variables, which are
+	// allocated on the heap, such that closures can share them.
+	boxed map[*types.Object]bool
+
 	labels      int
+	funcs       int
+	tags        []types.Type // types of union values, indexed by tag
 	frameStates []*frameState
 
 	frames []*Frame
@@ -52,20 +76,42 @@ type translator struct {
 
 // frameState represents the per-frame translator state.
 type frameState struct {
-	stack     int
-	vars      map[string]int
+	result    types.Type             // result type of the func, or nil
+	regs      int                    // number of virtual registers
+	vars      map[*types.Object]*Reg // registers of the variables or their boxes
+	env       *Reg                   // register of the closure
+	captures  map[*types.Object]int  // indices of the captured variables
 	forStarts []Label
 	forEnds   []Label
 }
 
-func (t *translator) translateFrame(b *ast.Block, label Label) {
+func (t *translator) translateFrame(label Label, f *types.Func, params []*ast.Field, captures []*types.Object, b *ast.Block) {
 	fs := &frameState{
-		vars: make(map[string]int),
+		vars:     make(map[*types.Object]*Reg),
+		captures: make(map[*types.Object]int),
 	}
 
+	// Append the frame before translating the block,
+	// such that the frames of nested func literals
+	// follow the frame of the enclosing one.
+	if f != nil {
+		fs.result = f.Result
+	}
+	frame := &Frame{Name: label}
+	t.frames = append(t.frames, frame)
 	t.frameStates = append(t.frameStates, fs)
 
-	s := t.translateCmd(b)
+	var s Seq
+	if len(captures) > 0 {
+		// The closure is passed in the result register.
+		fs.env = t.newReg(I64Reg)
+		for i, obj := range captures {
+			fs.captures[obj] = i
+		}
+		s = append(s, &Load{Src: result(I64Reg), Dst: fs.env, pos: b.Pos()})
+	}
+
+	s = append(s, t.translateParams(params), t.translateCmd(b))
 	s = flatten(s)
 	for _, p := range t.passes {
 		s = p(s)
@@ -74,8 +120,39 @@ func (t *translator) translateFrame(b *ast.Block, label Label) {
 	i := len(t.frameStates) - 1
 	t.frameStates = t.frameStates[:i]
 
-	frame := &Frame{Name: label, Seq: s, Stack: -fs.stack}
-	t.frames = append(t.frames, frame)
+	frame.Seq = s
+}
+
+// translateParams moves the args passed in the
+// argument registers into the registers of the params.
+func (t *translator) translateParams(params []*ast.Field) (s Seq) {
+	ts := make([]types.Type, 0, len(params))
+	for _, p := range params {
+		ts = append(ts, t.info.Uses[p.Ident].Type)
+	}
+	for i, r := range argRegs(ts) {
+		p := params[i]
+		arg := t.newReg(r.Type)
+		t.fs().vars[t.info.Uses[p.Ident]] = arg
+		s = append(s, &Load{Src: r, Dst: arg, pos: p.Pos()})
+	}
+
+	// Move the captured params into boxes after moving all
+	// args, since allocating a box overwrites the argument registers.
+	for i, p := range params {
+		obj := t.info.Uses[p.Ident]
+		if !t.boxed[obj] {
+			continue
+		}
+		arg := t.fs().vars[obj]
+		box := t.newReg(I64Reg)
+		t.fs().vars[obj] = box
+		s = append(s,
+			t.malloc(ts[i].Size(), box, p.Pos()),
+			&Store{Src: arg, Dst: &Mem{Base: box}, Size: arg.Type, pos: p.Pos()},
+		)
+	}
+	return s
 }
 
 func (t *translator) translateCmd(cmd ast.Cmd) Seq {
@@ -90,12 +167,14 @@ func (t *translator) translateCmd(cmd ast.Cmd) Seq {
 		return t.translateBreak(cmd)
 	case *ast.Continue:
 		return t.translateContinue(cmd)
+	case *ast.ExprCmd:
+		return t.translateExprCmd(cmd)
 	case *ast.For:
 		return t.translateFor(cmd)
 	case *ast.If:
 		return t.translateIf(cmd)
 	case *ast.Return:
-		return Seq{&Return{pos: cmd.Pos()}}
+		return t.translateReturn(cmd)
 	case *ast.VarDecl:
 		return t.translateVarDecl(cmd)
 	default:
@@ -107,20 +186,24 @@ func (t *translator) translateAssert(a *ast.Assert) Seq {
 	label := t.label()
 	return Seq{
 		t.boolCheck(a.X, true_),
-		&CJump{Label: label, pos: a.Pos()},
-		&Load{Src: I64(a.Pos().Line), Dst: i64Reg2, pos: a.Pos()},
+		&CJump{Label: label, pos: a.Pos(), cond: a.X},
+		&Load{Src: I64(a.Pos().Line()), Dst: lineReg, pos: a.Pos()},
 		&Call{Label: assertViolated, pos: a.Pos()},
 		label,
 	}
 }
 
 func (t *translator) translateAssign(a *ast.Assign) Seq {
-	src := t.translateRVal(a.X)
-	sz := t.info.Uses[a.Ident].Type.Size()
-	off := t.fs().vars[a.Ident.Name]
-	mem := &Mem{Off: off}
-	store := &Store{Src: src, Dst: mem, Size: regType(sz), pos: a.Pos()}
-	return Seq{store}
+	lhs, ok := a.LHS.(*ast.IndexExpr)
+	if !ok {
+		return t.store(t.info.Uses[a.LHS.(*ast.Ident)], a.X, a.Pos())
+	}
+
+	seq, mem := t.translateIndex(lhs)
+	typ := t.info.Types[lhs].Type
+	return append(seq,
+		&Store{Src: t.convert(a.X, typ), Dst: mem, Size: regType(typ), pos: a.Pos()},
+	)
 }
 
 func (t *translator) translateBlock(b *ast.Block) (s Seq) {
@@ -143,6 +226,13 @@ func (t *translator) translateContinue(c *ast.Continue) Seq {
 	}
 }
 
+func (t *translator) translateExprCmd(c *ast.ExprCmd) Seq {
+	if x, ok := t.translateRVal(c.X).(*seqExpr); ok {
+		return x.Seq
+	}
+	return nil
+}
+
 func (t *translator) translateFor(f *ast.For) Seq {
 	start := t.label()
 	end := t.label()
@@ -167,8 +257,11 @@ func (t *translator) translateIf(i *ast.If) Seq {
 	seq := Seq{
 		t.boolCheck(i.X, false_),
 		&CJump{Label: end, pos: i.Pos()},
-		t.translateCmd(i.Block),
 	}
+	for _, obj := range t.info.Narrowings[i] {
+		seq = append(seq, t.narrow(obj, i.Pos()))
+	}
+	seq = append(seq, t.translateCmd(i.Block))
 	if i.Else != nil {
 		endElse := t.label()
 		seq = append(seq, Seq{
@@ -181,107 +274,230 @@ func (t *translator) translateIf(i *ast.If) Seq {
 	return append(seq, end)
 }
 
+func (t *translator) translateReturn(r *ast.Return) Seq {
+	val := t.convert(r.X, t.fs().result)
+	return Seq{
+		&Load{Src: val, Dst: result(regType(t.fs().result)), pos: r.Pos()},
+		&Return{pos: r.Pos()},
+	}
+}
+
 func (t *translator) translateVarDecl(d *ast.VarDecl) Seq {
-	src := t.translateRVal(d.X)
-	sz := t.info.Uses[d.Ident].Type.Size()
-	t.fs().stack -= sz
-	t.fs().vars[d.Ident.Name] = t.fs().stack
-	mem := &Mem{Off: t.fs().stack}
-	store := &Store{Src: src, Dst: mem, Size: regType(sz), pos: d.Pos()}
-	return Seq{store}
+	obj := t.info.Uses[d.Ident]
+	return Seq{
+		t.define(obj, d.Pos()),
+		t.store(obj, d.X, d.Pos()),
+	}
 }
 
-func (t *translator) boolCheck(x ast.Expr, expect Bool) Seq {
-	check := t.translateRVal(x)
+// narrow defines the narrowed variable as a copy
+// of the value in the union of the original variable.
+func (t *translator) narrow(obj *types.Object, pos lexer.Pos) Seq {
+	seq, u := t.reg(t.load(obj.Orig, pos), I64Reg, pos)
+	return append(seq,
+		t.define(obj, pos),
+		t.assign(obj, &Mem{Base: u, Off: 8}, pos),
+	)
+}
+
+// define allocates the register of the variable. The register
+// of a boxed variable holds the address of its box on the heap.
+func (t *translator) define(obj *types.Object, pos lexer.Pos) Seq {
+	if !t.boxed[obj] {
+		t.fs().vars[obj] = t.newReg(regType(obj.Type))
+		return nil
+	}
+	box := t.newReg(I64Reg)
+	t.fs().vars[obj] = box
+	return t.malloc(obj.Type.Size(), box, pos)
+}
+
+// store stores the value of the expr in the variable.
+func (t *translator) store(obj *types.Object, x ast.Expr, pos lexer.Pos) Seq {
+	return t.assign(obj, t.convert(x, obj.Type), pos)
+}
+
+// assign assigns the value to the variable.
+func (t *translator) assign(obj *types.Object, val RVal, pos lexer.Pos) Seq {
+	seq, box := t.box(obj, pos)
+	if box == nil {
+		return Seq{&Load{Src: val, Dst: t.fs().vars[obj], pos: pos}}
+	}
+	return append(seq, &Store{Src: val, Dst: &Mem{Base: box}, Size: regType(obj.Type), pos: pos})
+}
+
+// load loads the value of the variable.
+func (t *translator) load(obj *types.Object, pos lexer.Pos) RVal {
+	seq, box := t.box(obj, pos)
+	if box == nil {
+		return t.fs().vars[obj]
+	}
+	r := t.newReg(regType(obj.Type))
+	seq = append(seq, &Load{Src: &Mem{Base: box}, Dst: r, pos: pos})
+	return &seqExpr{Seq: seq, Dst: r}
+}
+
+// box returns the register holding the address of the box of the
+// variable, or nil, if the variable is not boxed. The addresses of
+// the boxes of captured variables are loaded from the closure by
+// the returned sequence.
+func (t *translator) box(obj *types.Object, pos lexer.Pos) (Seq, *Reg) {
+	if i, ok := t.fs().captures[obj]; ok {
+		r := t.newReg(I64Reg)
+		return Seq{
+			&Load{Src: &Mem{Base: t.fs().env, Off: 8 * (i + 1)}, Dst: r, pos: pos},
+		}, r
+	}
+	if t.boxed[obj] {
+		return nil, t.fs().vars[obj]
+	}
+	return nil, nil
+}
+
+// malloc allocates memory of the given size on the
+// heap and moves its address into the given register.
+func (t *translator) malloc(sz int, dst *Reg, pos lexer.Pos) Seq {
 	return Seq{
-		&Load{Src: check, Dst: boolReg1, pos: x.Pos()},
-		&BinaryInstr{RHS: boolReg1, Op: Cmp, LHS: expect, pos: x.Pos()},
+		&Load{Src: I64(sz), Dst: &Reg{Type: I64Reg, Arg: 1}, pos: pos},
+		&Call{Func: malloc, pos: pos},
+		&Load{Src: result(I64Reg), Dst: dst, pos: pos},
 	}
 }
 
-func (t *translator) translateRVal(x ast.Expr) RVal {
-	switch x := x.(type) {
-	case *ast.BinaryExpr:
-		r1, r2 := i64Reg1, i64Reg2
-		if types.Equal(t.info.Types[x.LHS].Type, &types.Bool{}) {
-			r1, r2 = boolReg1, boolReg2
-		}
+// convert translates the expr and converts its value to the given
+// type. A value is converted to a union by boxing it with its tag.
+func (t *translator) convert(x ast.Expr, typ types.Type) RVal {
+	val := t.translateRVal(x)
+	from := t.info.Types[x].Type
+	if _, ok := typ.(*types.Union); !ok {
+		return val
+	}
+	if _, ok := from.(*types.Union); ok {
+		return val
+	}
 
-		var seq Seq
-		rhs := t.translateRVal(x.RHS)
-		if seqx, ok := rhs.(*seqExpr); ok {
-			seq = append(seq, seqx.Seq...)
-			rhs = seqx.Dst
-		}
+	seq, v := split(val)
+	u := t.newReg(I64Reg)
+	seq = append(seq,
+		t.malloc(16, u, x.Pos()),
+		&Store{Src: t.tag(from), Dst: &Mem{Base: u}, Size: I64Reg, pos: x.Pos()},
+		&Store{Src: v, Dst: &Mem{Base: u, Off: 8}, Size: regType(from), pos: x.Pos()},
+	)
+	return &seqExpr{Seq: seq, Dst: u}
+}
 
-		// Desugaring of a => b into ¬a ∨ b.
-		if x.Op == lexer.Implies {
-			x.LHS = &ast.UnaryExpr{X: x.LHS, Op: lexer.Not, StartPos: x.Pos()}
+// tag returns the tag of values of the given type in unions.
+func (t *translator) tag(typ types.Type) I64 {
+	for i, u := range t.tags {
+		if types.Equal(typ, u) {
+			return I64(i)
 		}
+	}
+	t.tags = append(t.tags, typ)
+	return I64(len(t.tags) - 1)
+}
 
-		// Push RHS onto the stack.
-		r, pushed := rhs.(*Reg)
-		if pushed {
-			pr := i64Reg1
-			if r.Second {
-				pr = i64Reg2
-			}
-			seq = append(seq, &UnaryInstr{Reg: pr, Op: Push, pos: x.Pos()})
-		}
+// split splits the value into the sequence computing it and the result.
+func split(val RVal) (Seq, RVal) {
+	if x, ok := val.(*seqExpr); ok {
+		return x.Seq, x.Dst
+	}
+	return nil, val
+}
+
+// reg returns the register holding the value. Values,
+// which are not in a register, are loaded into a new one.
+func (t *translator) reg(val RVal, rt RegType, pos lexer.Pos) (Seq, *Reg) {
+	seq, v := split(val)
+	if r, ok := v.(*Reg); ok {
+		return seq, r
+	}
+	r := t.newReg(rt)
+	return append(seq, &Load{Src: v, Dst: r, pos: pos}), r
+}
 
-		// Load LHS into the first register.
-		lhs := t.translateRVal(x.LHS)
-		seq = append(seq, &Load{Src: lhs, Dst: r1, pos: x.Pos()})
+// temp returns a register holding the value, which may be
+// modified. The registers of variables are copied first.
+func (t *translator) temp(val RVal, rt RegType, pos lexer.Pos) (Seq, *Reg) {
+	if x, ok := val.(*seqExpr); ok {
+		return x.Seq, x.Dst
+	}
+	r := t.newReg(rt)
+	return Seq{&Load{Src: val, Dst: r, pos: pos}}, r
+}
 
-		// Load RHS into the second register.
-		if pushed {
-			seq = append(seq, &UnaryInstr{Reg: i64Reg2, Op: Pop, pos: x.Pos()})
-		} else {
-			seq = append(seq, &Load{Src: rhs, Dst: r2, pos: x.Pos()})
-		}
+func (t *translator) boolCheck(x ast.Expr, expect Bool) Seq {
+	seq, r := t.reg(t.translateRVal(x), BoolReg, x.Pos())
+	return append(seq, &BinaryInstr{RHS: r, Op: Cmp, LHS: expect, pos: x.Pos()})
+}
 
-		seq = append(seq, &BinaryInstr{RHS: r1, Op: binOp(x.Op), LHS: r2, pos: x.Pos()})
-		if isCmp(x.Op) {
-			r1 = boolReg1
-			seq = append(seq, &UnaryInstr{Reg: r1, Op: cmpOp(x.Op), pos: x.Pos()})
+func (t *translator) translateRVal(x ast.Expr) RVal {
+	switch x := x.(type) {
+	case *ast.ArrayLit:
+		return t.translateArrayLit(x)
+	case *ast.BinaryExpr:
+		if lexer.And <= x.Op && x.Op <= lexer.Implies {
+			return t.translateLogical(x)
 		}
-		return &seqExpr{Seq: seq, Dst: r1}
+		return t.translateBinaryExpr(x)
 	case *ast.Bool:
 		return Bool(x.Val == "true")
+	case *ast.CallExpr:
+		return t.translateCallExpr(x)
 	case *ast.F64:
 		val, err := strconv.ParseFloat(x.Val, 64)
 		if err != nil {
 			panic(fmt.Sprintf("cannot convert f64: %v", err))
 		}
 		return F64(val)
+	case *ast.FuncLit:
+		return t.translateFuncLit(x)
 	case *ast.I64:
-		val, err := strconv.ParseInt(x.Val, 10, 0)
+		val, err := strconv.ParseInt(strings.ReplaceAll(x.Val, "_", ""), 10, 0)
 		if err != nil {
 			panic(fmt.Sprintf("cannot convert i64: %v", err))
 		}
 		return I64(val)
 	case *ast.Ident:
-		return &Mem{Off: t.fs().vars[x.Name]}
+		return t.load(t.info.Uses[x], x.Pos())
+	case *ast.IsExpr:
+		seq, u := t.reg(t.translateRVal(x.X), I64Reg, x.Pos())
+		tag := t.newReg(I64Reg)
+		r := t.newReg(BoolReg)
+		seq = append(seq,
+			&Load{Src: &Mem{Base: u}, Dst: tag, pos: x.Pos()},
+			&BinaryInstr{RHS: tag, Op: Cmp, LHS: t.tag(t.info.Tested[x]), pos: x.Pos()},
+			&UnaryInstr{Reg: r, Op: Sete, pos: x.Pos()},
+		)
+		return &seqExpr{Seq: seq, Dst: r}
+	case *ast.IndexExpr:
+		seq, mem := t.translateIndex(x)
+		r := t.newReg(regType(t.info.Types[x].Type))
+		seq = append(seq, &Load{Src: mem, Dst: r, pos: x.Pos()})
+		return &seqExpr{Seq: seq, Dst: r}
 	case *ast.ParenExpr:
 		return t.translateRVal(x.X)
+	case *ast.String:
+		val, err := strconv.Unquote(x.Val)
+		if err != nil {
+			panic(fmt.Sprintf("cannot convert string: %v", err))
+		}
+		return String(val)
 	case *ast.UnaryExpr:
 		switch x.Op {
 		case lexer.Minus:
-			val := t.translateRVal(x.X)
-			return &seqExpr{
-				Seq: Seq{
-					&Load{Src: val, Dst: i64Reg1, pos: x.Pos()},
-					&UnaryInstr{Op: Neg, Reg: i64Reg1, pos: x.Pos()},
-				},
-				Dst: i64Reg1,
-			}
+			rt := regType(t.info.Types[x.X].Type)
+			seq, r := t.temp(t.translateRVal(x.X), rt, x.Pos())
+			seq = append(seq, &UnaryInstr{Op: Neg, Reg: r, pos: x.Pos()})
+			return &seqExpr{Seq: seq, Dst: r}
 		case lexer.Not:
+			r := t.newReg(BoolReg)
 			return &seqExpr{
 				Seq: Seq{
 					t.boolCheck(x.X, true_),
-					&UnaryInstr{Op: Setne, Reg: boolReg1, pos: x.Pos()},
+					&UnaryInstr{Op: Setne, Reg: r, pos: x.Pos()},
 				},
-				Dst: boolReg1,
+				Dst: r,
 			}
 		default:
 			panic(fmt.Sprintf("unexpected operator %s", x.Op))
@@ -291,6 +507,399 @@ func (t *translator) translateRVal(x ast.Expr) RVal {
 	}
 }
 
+func (t *translator) translateBinaryExpr(x *ast.BinaryExpr) RVal {
+	lhs := t.info.Types[x.LHS].Type
+	rhs := t.info.Types[x.RHS].Type
+
+	// Evaluate RHS before LHS.
+	seq, r2 := t.reg(t.translateRVal(x.RHS), regType(rhs), x.Pos())
+	val := t.translateRVal(x.LHS)
+
+	if x.Op == lexer.In {
+		s, r1 := t.reg(val, regType(lhs), x.Pos())
+		s2, dst := t.in(lhs, rhs, r1, r2, x.Pos())
+		return &seqExpr{Seq: append(seq, s, s2), Dst: dst}
+	}
+	if _, ok := lhs.(*types.String); ok {
+		s, r1 := t.reg(val, I64Reg, x.Pos())
+		s2, dst := t.strOp(x.Op, r1, r2, x.Pos())
+		return &seqExpr{Seq: append(seq, s, s2), Dst: dst}
+	}
+	if isCmp(x.Op) {
+		s, r1 := t.reg(val, regType(lhs), x.Pos())
+		seq = append(seq, s)
+		if r1.Type == F64Reg {
+			s, dst := t.f64Cmp(x.Op, r1, r2, x.Pos())
+			return &seqExpr{Seq: append(seq, s), Dst: dst}
+		}
+		dst := t.newReg(BoolReg)
+		seq = append(seq,
+			&BinaryInstr{RHS: r1, Op: Cmp, LHS: r2, pos: x.Pos()},
+			&UnaryInstr{Reg: dst, Op: cmpOp(x.Op), pos: x.Pos()},
+		)
+		return &seqExpr{Seq: seq, Dst: dst}
+	}
+
+	s, r1 := t.temp(val, regType(lhs), x.Pos())
+	seq = append(seq, s)
+	if x.Op == lexer.Divide && r2.Type == I64Reg {
+		seq = append(seq, t.checkDivisor(r2, x.Pos()))
+	}
+	seq = append(seq, &BinaryInstr{RHS: r1, Op: binOp(x.Op), LHS: r2, pos: x.Pos()})
+	return &seqExpr{Seq: seq, Dst: r1}
+}
+
+// checkDivisor checks, whether the i64 divisor is zero. The division
+// would trap otherwise, which is reported like a violated assert.
+func (t *translator) checkDivisor(divisor *Reg, pos lexer.Pos) Seq {
+	nonZero := t.label()
+	zero := t.newReg(BoolReg)
+	return Seq{
+		&BinaryInstr{RHS: divisor, Op: Cmp, LHS: I64(0), pos: pos},
+		&UnaryInstr{Reg: zero, Op: Sete, pos: pos},
+		&BinaryInstr{RHS: zero, Op: Cmp, LHS: false_, pos: pos},
+		&CJump{Label: nonZero, pos: pos},
+		&Load{Src: I64(pos.Line()), Dst: lineReg, pos: pos},
+		&Call{Label: divisionByZero, pos: pos},
+		nonZero,
+	}
+}
+
+// translateLogical translates a ∧ b, a ∨ b and a ⟹ b, such
+// that b is only evaluated, if a does not determine the result.
+func (t *translator) translateLogical(x *ast.BinaryExpr) RVal {
+	end := t.label()
+	r := t.newReg(BoolReg)
+	seq := Seq{&Load{Src: t.translateRVal(x.LHS), Dst: r, pos: x.Pos()}}
+	switch x.Op {
+	case lexer.And:
+		// a ∧ b is false, if a is false.
+		seq = append(seq, &BinaryInstr{RHS: r, Op: Cmp, LHS: false_, pos: x.Pos()})
+	case lexer.Or:
+		// a ∨ b is true, if a is true.
+		seq = append(seq, &BinaryInstr{RHS: r, Op: Cmp, LHS: true_, pos: x.Pos()})
+	case lexer.Implies:
+		// a ⟹ b is ¬a ∨ b, which is true, if ¬a is true.
+		seq = append(seq,
+			&BinaryInstr{RHS: r, Op: Cmp, LHS: true_, pos: x.Pos()},
+			&UnaryInstr{Reg: r, Op: Setne, pos: x.Pos()},
+			&BinaryInstr{RHS: r, Op: Cmp, LHS: true_, pos: x.Pos()},
+		)
+	default:
+		panic(fmt.Sprintf("unexpected operator %s", x.Op))
+	}
+	seq = append(seq,
+		&CJump{Label: end, pos: x.Pos()},
+		&Load{Src: t.translateRVal(x.RHS), Dst: r, pos: x.Pos()},
+		end,
+	)
+	return &seqExpr{Seq: seq, Dst: r}
+}
+
+// f64Cmp compares the f64 values in the given registers and returns
+// the register of the result. An unordered comparison, i.e. with
+// a NaN operand, only satisfies ≠.
+func (t *translator) f64Cmp(op lexer.Tok, r1, r2 *Reg, pos lexer.Pos) (Seq, *Reg) {
+	dst := t.newReg(BoolReg)
+	switch op {
+	case lexer.Less, lexer.LessEq:
+		// Swap the operands, since the flags of an
+		// unordered comparison satisfy "below".
+		return Seq{
+			&BinaryInstr{RHS: r2, Op: Cmp, LHS: r1, pos: pos},
+			&UnaryInstr{Reg: dst, Op: f64CmpOps[op], pos: pos},
+		}, dst
+	case lexer.Greater, lexer.GreaterEq:
+		return Seq{
+			&BinaryInstr{RHS: r1, Op: Cmp, LHS: r2, pos: pos},
+			&UnaryInstr{Reg: dst, Op: f64CmpOps[op], pos: pos},
+		}, dst
+	case lexer.Equal:
+		ordered := t.newReg(BoolReg)
+		return Seq{
+			&BinaryInstr{RHS: r1, Op: Cmp, LHS: r2, pos: pos},
+			&UnaryInstr{Reg: dst, Op: Sete, pos: pos},
+			&UnaryInstr{Reg: ordered, Op: Setnp, pos: pos},
+			&BinaryInstr{RHS: dst, Op: And, LHS: ordered, pos: pos},
+		}, dst
+	case lexer.NotEqual:
+		unordered := t.newReg(BoolReg)
+		return Seq{
+			&BinaryInstr{RHS: r1, Op: Cmp, LHS: r2, pos: pos},
+			&UnaryInstr{Reg: dst, Op: Setne, pos: pos},
+			&UnaryInstr{Reg: unordered, Op: Setp, pos: pos},
+			&BinaryInstr{RHS: dst, Op: Or, LHS: unordered, pos: pos},
+		}, dst
+	default:
+		panic(fmt.Sprintf("unexpected op %s", op))
+	}
+}
+
+// strOp applies the op to the strings in the given registers
+// by calling the runtime. It returns the register of the result.
+func (t *translator) strOp(op lexer.Tok, r1, r2 *Reg, pos lexer.Pos) (Seq, *Reg) {
+	fn := langStrcmp
+	if op == lexer.Plus {
+		fn = langConcat
+	}
+	seq := Seq{
+		&Load{Src: r1, Dst: &Reg{Type: I64Reg, Arg: 1}, pos: pos},
+		&Load{Src: r2, Dst: &Reg{Type: I64Reg, Arg: 2}, pos: pos},
+		&Call{Func: fn, pos: pos},
+	}
+	if op == lexer.Plus {
+		dst := t.newReg(I64Reg)
+		return append(seq, &Load{Src: result(I64Reg), Dst: dst, pos: pos}), dst
+	}
+	dst := t.newReg(BoolReg)
+	return append(seq,
+		&BinaryInstr{RHS: result(I64Reg), Op: Cmp, LHS: I64(0), pos: pos},
+		&UnaryInstr{Reg: dst, Op: cmpOp(op), pos: pos},
+	), dst
+}
+
+// translateArrayLit allocates the length
+// followed by the elems on the heap.
+func (t *translator) translateArrayLit(l *ast.ArrayLit) RVal {
+	var (
+		elem types.Type
+		n    int
+	)
+	switch typ := t.info.Types[l].Type.(type) {
+	case *types.Array:
+		elem, n = typ.Elem, int(typ.Len)
+	case *types.Slice:
+		elem, n = typ.Elem, len(l.Elems)
+	}
+
+	// Missing elems are zeroed by calloc.
+	sz := elem.Size()
+	arr := t.newReg(I64Reg)
+	seq := Seq{
+		&Load{Src: I64(1), Dst: &Reg{Type: I64Reg, Arg: 1}, pos: l.Pos()},
+		&Load{Src: I64(8 + n*sz), Dst: &Reg{Type: I64Reg, Arg: 2}, pos: l.Pos()},
+		&Call{Func: calloc, pos: l.Pos()},
+		&Load{Src: result(I64Reg), Dst: arr, pos: l.Pos()},
+		&Store{Src: I64(n), Dst: &Mem{Base: arr}, Size: I64Reg, pos: l.Pos()},
+	}
+	for i, x := range l.Elems {
+		dst := &Mem{Base: arr, Off: 8 + i*sz}
+		seq = append(seq, &Store{Src: t.convert(x, elem), Dst: dst, Size: regType(elem), pos: x.Pos()})
+	}
+	return &seqExpr{Seq: seq, Dst: arr}
+}
+
+// translateIndex evaluates the array and the index and
+// checks, whether the index is in bounds. It returns
+// the memory of the elem.
+func (t *translator) translateIndex(x *ast.IndexExpr) (Seq, *Mem) {
+	seq, arr := t.reg(t.translateRVal(x.X), I64Reg, x.Pos())
+	s, index := t.reg(t.translateRVal(x.Index), I64Reg, x.Index.Pos())
+	seq = append(seq, s...)
+
+	// Compare the index and the length as unsigned
+	// values, such that negative indices are out of
+	// bounds.
+	inBounds := t.label()
+	violated := t.newReg(BoolReg)
+	seq = append(seq,
+		&BinaryInstr{RHS: index, Op: Cmp, LHS: &Mem{Base: arr}, pos: x.Pos()},
+		&UnaryInstr{Reg: violated, Op: Setae, pos: x.Pos()},
+		&BinaryInstr{RHS: violated, Op: Cmp, LHS: false_, pos: x.Pos()},
+		&CJump{Label: inBounds, pos: x.Pos()},
+		&Load{Src: I64(x.Pos().Line()), Dst: lineReg, pos: x.Pos()},
+		&Call{Label: boundsViolated, pos: x.Pos()},
+		inBounds,
+	)
+
+	sz := t.info.Types[x].Type.Size()
+	return seq, &Mem{Base: arr, Index: index, Scale: sz, Off: 8}
+}
+
+// in calls the runtime to check, whether the value in the first
+// register is an elem of the array or a substring of the string
+// in the second register. It returns the register of the result.
+func (t *translator) in(lhs, rhs types.Type, r1, r2 *Reg, pos lexer.Pos) (Seq, *Reg) {
+	fn := langSubstring
+	if _, ok := rhs.(*types.String); !ok {
+		fn = elemFunc(lhs)
+	}
+	args := argRegs([]types.Type{lhs, rhs})
+	dst := t.newReg(BoolReg)
+	return Seq{
+		&Load{Src: r1, Dst: args[0], pos: pos},
+		&Load{Src: r2, Dst: args[1], pos: pos},
+		&Call{Func: fn, pos: pos},
+		&BinaryInstr{RHS: result(I64Reg), Op: Cmp, LHS: I64(0), pos: pos},
+		&UnaryInstr{Reg: dst, Op: Setne, pos: pos},
+	}, dst
+}
+
+func (t *translator) translateCallExpr(x *ast.CallExpr) RVal {
+	if b, ok := t.info.Types[x.Func].Type.(*types.Builtin); ok {
+		return t.translateBuiltinCall(x, b)
+	}
+
+	var seq Seq
+	f := t.info.Types[x.Func].Type.(*types.Func)
+
+	// Evaluate the args before loading them into the argument
+	// registers, such that calls in subsequent args cannot
+	// overwrite them.
+	args := make([]RVal, 0, len(x.Args))
+	for i, a := range x.Args {
+		s, arg := split(t.convert(a, f.Params[i]))
+		seq = append(seq, s...)
+		args = append(args, arg)
+	}
+	s, fn := t.reg(t.translateRVal(x.Func), I64Reg, x.Pos())
+	seq = append(seq, s...)
+
+	for i, r := range argRegs(f.Params) {
+		seq = append(seq, &Load{Src: args[i], Dst: r, pos: x.Args[i].Pos()})
+	}
+
+	// The closure is passed in the result register.
+	closure := result(I64Reg)
+	dst := t.newReg(regType(f.Result))
+	seq = append(seq,
+		&Load{Src: fn, Dst: closure, pos: x.Pos()},
+		&Call{Func: &Mem{Base: closure}, pos: x.Pos()},
+		&Load{Src: result(dst.Type), Dst: dst, pos: x.Pos()},
+	)
+	return &seqExpr{Seq: seq, Dst: dst}
+}
+
+// translateBuiltinCall translates a call of len into a load of the
+// length, and a call of print or println into a call of the runtime
+// for each arg. The args are evaluated first, such that calls in
+// args are completed before anything is printed.
+func (t *translator) translateBuiltinCall(x *ast.CallExpr, b *types.Builtin) RVal {
+	if b.Name == "len" {
+		// The length precedes the elems.
+		seq, arr := t.reg(t.translateRVal(x.Args[0]), I64Reg, x.Pos())
+		n := t.newReg(I64Reg)
+		seq = append(seq, &Load{Src: &Mem{Base: arr}, Dst: n, pos: x.Pos()})
+		return &seqExpr{Seq: seq, Dst: n}
+	}
+
+	var seq Seq
+	args := make([]RVal, 0, len(x.Args))
+	for _, a := range x.Args {
+		s, arg := split(t.translateRVal(a))
+		seq = append(seq, s...)
+		args = append(args, arg)
+	}
+
+	for i, a := range x.Args {
+		if i > 0 && b.Name == "println" {
+			seq = append(seq, printString(" ", a.Pos()))
+		}
+		typ := t.info.Types[a].Type
+		arg := argRegs([]types.Type{typ})[0]
+		seq = append(seq,
+			&Load{Src: args[i], Dst: arg, pos: a.Pos()},
+			&Call{Func: printFunc(typ), pos: a.Pos()},
+		)
+	}
+	if b.Name == "println" {
+		seq = append(seq, printString("\n", x.Pos()))
+	}
+	return &seqExpr{Seq: seq}
+}
+
+// printFunc returns the runtime func printing values of the given type.
+func printFunc(typ types.Type) Label {
+	switch typ.(type) {
+	case *types.Bool:
+		return langPrintBool
+	case *types.F64:
+		return langPrintF64
+	case *types.I64:
+		return langPrintI64
+	case *types.String:
+		return langPrintString
+	default:
+		panic(fmt.Sprintf("unexpected type %T", typ))
+	}
+}
+
+// elemFunc returns the runtime func checking the
+// membership of a value of the given type in an array.
+func elemFunc(typ types.Type) Label {
+	switch typ.(type) {
+	case *types.Bool:
+		return langElemBool
+	case *types.F64:
+		return langElemF64
+	case *types.I64:
+		return langElemI64
+	case *types.String:
+		return langElemString
+	default:
+		panic(fmt.Sprintf("unexpected type %T", typ))
+	}
+}
+
+func printString(s string, pos lexer.Pos) Seq {
+	return Seq{
+		&Load{Src: String(s), Dst: &Reg{Type: I64Reg, Arg: 1}, pos: pos},
+		&Call{Func: langPrintString, pos: pos},
+	}
+}
+
+// translateFuncLit translates the func literal into its own frame
+// and allocates a closure, which consists of the address of the frame,
+// followed by the addresses of the boxes of the captured variables.
+func (t *translator) translateFuncLit(f *ast.FuncLit) RVal {
+	t.funcs++
+	label := Label(fmt.Sprintf("func%d", t.funcs))
+	captures := t.info.Captures[f]
+	t.translateFrame(label, t.info.Types[f].Type.(*types.Func), f.Params, captures, f.Block)
+
+	closure := t.newReg(I64Reg)
+	seq := Seq{
+		t.malloc(8*(len(captures)+1), closure, f.Pos()),
+		&Store{Src: label, Dst: &Mem{Base: closure}, Size: I64Reg, pos: f.Pos()},
+	}
+	for i, obj := range captures {
+		s, box := t.box(obj, f.Pos())
+		dst := &Mem{Base: closure, Off: 8 * (i + 1)}
+		seq = append(seq, s, &Store{Src: box, Dst: dst, Size: I64Reg, pos: f.Pos()})
+	}
+	return &seqExpr{Seq: seq, Dst: closure}
+}
+
+// argRegs returns the argument registers for the given
+// param types, following the System V calling convention.
+func argRegs(params []types.Type) []*Reg {
+	var regs []*Reg
+	ints, f64s := 0, 0
+	for _, p := range params {
+		rt := regType(p)
+		if rt == F64Reg {
+			f64s++
+			regs = append(regs, &Reg{Type: rt, Arg: f64s})
+		} else {
+			ints++
+			regs = append(regs, &Reg{Type: rt, Arg: ints})
+		}
+	}
+	return regs
+}
+
+// result returns the register, in which
+// values of the given type are returned.
+func result(rt RegType) *Reg {
+	return &Reg{Type: rt}
+}
+
+// newReg returns a new virtual register of the given type.
+func (t *translator) newReg(rt RegType) *Reg {
+	t.fs().regs++
+	return &Reg{Type: rt, Virt: t.fs().regs}
+}
+
 func (t *translator) label() Label {
 	t.labels++
 	return Label(fmt.Sprintf(".L%d", t.labels))
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"davidrjenni.io/lang/internal/errors"
 	"davidrjenni.io/lang/ir"
 	"davidrjenni.io/lang/parser"
 	"davidrjenni.io/lang/types"
@@ -31,6 +32,7 @@ func TestTranslate(t *testing.T) {
 	}
 
 	id := ir.Pass(func(s ir.Seq) ir.Seq { return s })
+	var errs errors.Errors
 
 	passes := [...]struct {
 		filename string
@@ -38,23 +40,52 @@ func TestTranslate(t *testing.T) {
 	}{
 		{filename: "input.golden", pass: id},
 		{filename: "input.loads.golden", pass: ir.Loads},
+		{filename: "input.fold.golden", pass: ir.Fold(&errs)},
+		{filename: "input.unreachable.golden", pass: ir.Unreachable},
 	}
 
 	for _, p := range passes {
-		frames := ir.Translate(b, info, p.pass)
-		cmpGolden(t, frames, p.filename, *update)
+		var actual bytes.Buffer
+		for _, f := range ir.Translate(b, info, p.pass) {
+			ir.Dump(&actual, f)
+		}
+		cmpGolden(t, actual.Bytes(), p.filename, *update)
+	}
+
+	expected := "test-fixtures/input.l:3:9: assertion is always violated"
+	if errs.Error() != expected {
+		t.Errorf("expected errors\n%s\ngot\n%s", expected, errs.Error())
 	}
 }
 
-func cmpGolden(t *testing.T, frames []*ir.Frame, filename string, update bool) {
-	var actual bytes.Buffer
-	for _, f := range frames {
-		ir.Dump(&actual, f)
+func TestTranslateTwice(t *testing.T) {
+	filename := filepath.Join("test-fixtures", "input.l")
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
 	}
 
+	info, err := types.Check(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var first, second bytes.Buffer
+	for _, f := range ir.Translate(b, info) {
+		ir.Dump(&first, f)
+	}
+	for _, f := range ir.Translate(b, info) {
+		ir.Dump(&second, f)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("expected\n%s\ngot\n%s\n", first.String(), second.String())
+	}
+}
+
+func cmpGolden(t *testing.T, actual []byte, filename string, update bool) {
 	golden := filepath.Join("test-fixtures", filename)
 	if update {
-		if err := ioutil.WriteFile(golden, actual.Bytes(), 0644); err != nil {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
 			t.Fatalf("cannot update golden file: %v", err)
 		}
 	}
@@ -64,7 +95,7 @@ func cmpGolden(t *testing.T, frames []*ir.Frame, filename string, update bool) {
 		t.Fatalf("cannot read golden file: %v", err)
 	}
 
-	if !bytes.Equal(actual.Bytes(), expected) {
-		t.Errorf("%s: expected\n%s\ngot\n%s\n", filename, string(expected), actual.String())
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("%s: expected\n%s\ngot\n%s\n", filename, string(expected), string(actual))
 	}
 }
@@ -5,8 +5,9 @@
 package lexer // import "davidrjenni.io/lang/lexer"
 
 import (
-	"bufio"
+	"bytes"
 	"io"
+	"io/ioutil"
 	"unicode"
 )
 
@@ -15,26 +16,37 @@ const eof = -1
 
 // Lexer holds the state of the lexical analyzer.
 type Lexer struct {
-	r   *bufio.Reader // source reader
-	ch  rune          // current rune
-	w   uint32        // width of current rune
-	pos Pos           // current position
+	r    *bytes.Reader // source reader
+	file *File         // source file
+	ch   rune          // current rune
+	w    int           // width of current rune
+	off  int           // offset of current rune
 }
 
 // New creates a new lexer which reads source code from the given reader.
 // If the lexer cannot read from the reader, an error is returned.
 func New(r io.Reader, filename string) (*Lexer, error) {
-	l := &Lexer{
-		r:   bufio.NewReader(r),
-		w:   1,
-		pos: Pos{Filename: filename, Line: 1},
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
+	return NewFromFile(NewFile(filename, src))
+}
+
+// NewFromFile creates a new lexer which reads the source code of the file.
+func NewFromFile(f *File) (*Lexer, error) {
+	l := &Lexer{r: bytes.NewReader(f.src), file: f}
 
 	// Initialize the current rune and position.
 	err := l.next()
 	return l, err
 }
 
+// File returns the source file.
+func (l *Lexer) File() *File {
+	return l.file
+}
+
 // Read reads the next token in the reader and returns its
 // position, token type and literal or returns an error.
 // For unknown tokens, Illegal is returned as token type.
@@ -48,7 +60,7 @@ func (l *Lexer) Read() (pos Pos, tok Tok, lit string, err error) {
 		}
 	}
 
-	pos, lit = l.pos, string(l.ch)
+	pos, lit = l.file.Pos(l.off), string(l.ch)
 	ch := l.ch
 
 	if unicode.IsLetter(l.ch) || l.ch == '_' {
@@ -170,18 +182,14 @@ func (l *Lexer) next() error {
 	ch, sz, err := l.r.ReadRune()
 	switch {
 	case err == io.EOF:
-		ch, sz = eof, 1
+		ch, sz = eof, 0
 	case err != nil:
 		return err
 	}
 
-	l.pos.Column += l.w
-	l.w = uint32(sz)
+	l.off += l.w
+	l.w = sz
 	l.ch = ch
-	if ch == '\n' {
-		l.pos.Line++
-		l.pos.Column = 0
-	}
 	return nil
 }
 
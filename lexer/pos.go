@@ -4,35 +4,251 @@
 
 package lexer // import "davidrjenni.io/lang/lexer"
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+)
 
-// Pos represents a position in the source code.
+// Pos represents a position in the source code, i.e. a byte
+// offset in a file. The zero value is an invalid position.
 type Pos struct {
-	Filename string // filename, optional
-	Line     uint32 // line number, starting at 1
-	Column   uint32 // column number within a line, starting at 1
+	file   *File
+	offset int
 }
 
-// Shift creates a new position starting at the
-// current position, adding the given number to
-// the column.
-func (p Pos) Shift(col int) Pos {
-	return Pos{
-		Filename: p.Filename,
-		Line:     p.Line,
-		Column:   p.Column + uint32(col),
+// File returns the file of the position, or nil.
+func (p Pos) File() *File { return p.file }
+
+// Offset returns the byte offset of the position in its file.
+func (p Pos) Offset() int { return p.offset }
+
+// IsValid reports whether the position is valid.
+func (p Pos) IsValid() bool { return p.file != nil }
+
+// Line returns the line number, starting at 1,
+// or 0, if the position is invalid.
+func (p Pos) Line() int {
+	if p.file == nil {
+		return 0
 	}
+	return p.file.line(p.offset)
+}
+
+// Column returns the column number in bytes, starting
+// at 1, or 0, if the position is invalid.
+func (p Pos) Column() int {
+	if p.file == nil {
+		return 0
+	}
+	return p.offset - p.file.lines[p.Line()-1] + 1
+}
+
+// RuneColumn returns the column number in runes, starting
+// at 1, or 0, if the position is invalid.
+func (p Pos) RuneColumn() int {
+	if p.file == nil {
+		return 0
+	}
+	return utf8.RuneCount(p.file.src[p.file.lines[p.Line()-1]:p.offset]) + 1
+}
+
+// UTF16Column returns the column number in UTF-16 code units,
+// starting at 1, or 0, if the position is invalid.
+func (p Pos) UTF16Column() int {
+	if p.file == nil {
+		return 0
+	}
+	n := 1
+	for _, ch := range string(p.file.src[p.file.lines[p.Line()-1]:p.offset]) {
+		n += len(utf16.Encode([]rune{ch}))
+	}
+	return n
+}
+
+// Shift creates a new position starting at the
+// current position, adding the given number of
+// bytes to the offset.
+func (p Pos) Shift(n int) Pos {
+	return Pos{file: p.file, offset: p.offset + n}
 }
 
 // String returns a string representation of the position.
 // An invalid position is indicated with "-".
 func (p Pos) String() string {
-	if p.Line > 0 && p.Column > 0 {
-		s := p.Filename
-		if s != "" {
-			s += ":"
+	if p.file == nil {
+		return "-"
+	}
+	s := p.file.name
+	if s != "" {
+		s += ":"
+	}
+	return fmt.Sprintf("%s%d:%d", s, p.Line(), p.Column())
+}
+
+func (p Pos) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Filename    string `json:"filename,omitempty"`
+		Offset      int    `json:"offset"`
+		Line        int    `json:"line"`
+		Column      int    `json:"column"`
+		UTF16Column int    `json:"utf16Column"`
+	}{
+		Filename:    p.file.Name(),
+		Offset:      p.offset,
+		Line:        p.Line(),
+		Column:      p.Column(),
+		UTF16Column: p.UTF16Column(),
+	})
+}
+
+// File is a source file. It maps the byte offsets
+// to lines and columns by a table of line offsets.
+type File struct {
+	name  string
+	src   []byte
+	lines []int // offsets of the first bytes of the lines
+}
+
+// NewFile creates a file with the given name and content.
+func NewFile(filename string, src []byte) *File {
+	f := &File{name: filename, src: src, lines: []int{0}}
+	for i, b := range src {
+		if b == '\n' {
+			f.lines = append(f.lines, i+1)
 		}
-		return fmt.Sprintf("%s%d:%d", s, p.Line, p.Column)
 	}
-	return "-"
+	return f
+}
+
+// Name returns the filename, or "" if f is nil.
+func (f *File) Name() string {
+	if f == nil {
+		return ""
+	}
+	return f.name
+}
+
+// Size returns the size of the content in bytes.
+func (f *File) Size() int { return len(f.src) }
+
+// LineCount returns the number of lines.
+func (f *File) LineCount() int { return len(f.lines) }
+
+// Line returns the content of the line, starting
+// at 1, without the newline, or nil.
+func (f *File) Line(line int) []byte {
+	if line < 1 || line > len(f.lines) {
+		return nil
+	}
+	end := len(f.src)
+	if line < len(f.lines) {
+		end = f.lines[line] - 1
+	}
+	return f.src[f.lines[line-1]:end]
+}
+
+// Pos returns the position at the byte offset,
+// which is clamped to the content.
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(f.src) {
+		offset = len(f.src)
+	}
+	return Pos{file: f, offset: offset}
+}
+
+// LinePos returns the position at the line and the column
+// in bytes, both starting at 1. The column is clamped to
+// the end of the line.
+func (f *File) LinePos(line, col int) Pos {
+	return f.linePos(line, col, func(l []byte, col int) int {
+		if col-1 > len(l) {
+			return len(l)
+		}
+		return col - 1
+	})
+}
+
+// UTF16Pos returns the position at the line and the column in
+// UTF-16 code units, both starting at 1. The column is clamped
+// to the end of the line.
+func (f *File) UTF16Pos(line, col int) Pos {
+	return f.linePos(line, col, func(l []byte, col int) int {
+		off, n := 0, 1
+		for _, ch := range string(l) {
+			if n >= col {
+				break
+			}
+			n += len(utf16.Encode([]rune{ch}))
+			off += utf8.RuneLen(ch)
+		}
+		return off
+	})
+}
+
+// linePos returns the position in the line, whose offset
+// in the line is computed from the column by offset.
+func (f *File) linePos(line, col int, offset func([]byte, int) int) Pos {
+	if line < 1 {
+		return f.Pos(0)
+	}
+	if line > len(f.lines) {
+		return f.Pos(len(f.src))
+	}
+	if col < 1 {
+		col = 1
+	}
+	return f.Pos(f.lines[line-1] + offset(f.Line(line), col))
+}
+
+// line returns the line of the offset, starting at 1.
+func (f *File) line(offset int) int {
+	return sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset })
+}
+
+// FileSet is a set of files, which are identified by their names.
+type FileSet struct {
+	files []*File
+}
+
+// Add adds the file to the set. It replaces
+// a file with the same name, if there is one.
+func (s *FileSet) Add(f *File) {
+	for i, g := range s.files {
+		if g.name == f.name {
+			s.files[i] = f
+			return
+		}
+	}
+	s.files = append(s.files, f)
+}
+
+// Remove removes the file with the given name from the set.
+func (s *FileSet) Remove(filename string) {
+	for i, f := range s.files {
+		if f.name == filename {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			return
+		}
+	}
+}
+
+// File returns the file with the given name, or nil.
+func (s *FileSet) File(filename string) *File {
+	for _, f := range s.files {
+		if f.name == filename {
+			return f
+		}
+	}
+	return nil
+}
+
+// Files returns the files in the order, they were added.
+func (s *FileSet) Files() []*File {
+	return s.files
 }
@@ -0,0 +1,102 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lexer_test
+
+import (
+	"strings"
+	"testing"
+
+	"davidrjenni.io/lang/lexer"
+)
+
+func TestPos(t *testing.T) {
+	const src = "{\n\tlet ä ≔ \"𝄞\" ⟹ x;\n}"
+	f := lexer.NewFile("a.l", []byte(src))
+
+	tests := [...]struct {
+		offset                       int
+		line, col, runeCol, utf16Col int
+		str                          string
+	}{
+		{offset: 0, line: 1, col: 1, runeCol: 1, utf16Col: 1, str: "a.l:1:1"},
+		{offset: 1, line: 1, col: 2, runeCol: 2, utf16Col: 2, str: "a.l:1:2"},
+		{offset: 2, line: 2, col: 1, runeCol: 1, utf16Col: 1, str: "a.l:2:1"},
+		{offset: strings.Index(src, "≔"), line: 2, col: 9, runeCol: 8, utf16Col: 8, str: "a.l:2:9"},
+		{offset: strings.Index(src, "⟹"), line: 2, col: 20, runeCol: 14, utf16Col: 15, str: "a.l:2:20"},
+		{offset: len(src), line: 3, col: 2, runeCol: 2, utf16Col: 2, str: "a.l:3:2"},
+	}
+
+	for _, test := range tests {
+		pos := f.Pos(test.offset)
+		if pos.Line() != test.line || pos.Column() != test.col {
+			t.Errorf("%d: expected %d:%d, got %d:%d", test.offset, test.line, test.col, pos.Line(), pos.Column())
+		}
+		if pos.RuneColumn() != test.runeCol {
+			t.Errorf("%d: expected rune column %d, got %d", test.offset, test.runeCol, pos.RuneColumn())
+		}
+		if pos.UTF16Column() != test.utf16Col {
+			t.Errorf("%d: expected UTF-16 column %d, got %d", test.offset, test.utf16Col, pos.UTF16Column())
+		}
+		if pos.String() != test.str {
+			t.Errorf("%d: expected %s, got %s", test.offset, test.str, pos)
+		}
+		if p := f.LinePos(test.line, test.col); p != pos {
+			t.Errorf("%d: expected offset %d for %d:%d, got %d", test.offset, test.offset, test.line, test.col, p.Offset())
+		}
+		if p := f.UTF16Pos(test.line, test.utf16Col); p != pos {
+			t.Errorf("%d: expected offset %d for UTF-16 %d:%d, got %d", test.offset, test.offset, test.line, test.utf16Col, p.Offset())
+		}
+	}
+
+	if s := (lexer.Pos{}).String(); s != "-" {
+		t.Errorf("expected -, got %s", s)
+	}
+	if l := string(f.Line(2)); l != "\tlet ä ≔ \"𝄞\" ⟹ x;" {
+		t.Errorf("unexpected line %q", l)
+	}
+}
+
+func TestLexerPos(t *testing.T) {
+	const src = "{ let ä ≔ 1; }"
+	l, err := lexer.New(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatalf("cannot initialize lexer: %v", err)
+	}
+
+	var ends []string
+	for {
+		pos, tok, lit, err := l.Read()
+		if err != nil {
+			t.Fatalf("cannot read from lexer: %v", err)
+		}
+		if tok == lexer.EOF {
+			break
+		}
+		end := pos.Shift(len(lit))
+		ends = append(ends, src[pos.Offset():end.Offset()])
+	}
+
+	expected := []string{"{", "let", "ä", "≔", "1", ";", "}"}
+	if strings.Join(ends, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %q, got %q", expected, ends)
+	}
+}
+
+func TestFileSet(t *testing.T) {
+	var s lexer.FileSet
+	a, b := lexer.NewFile("a.l", nil), lexer.NewFile("b.l", nil)
+	s.Add(a)
+	s.Add(b)
+	if s.File("a.l") != a || s.File("b.l") != b || s.File("c.l") != nil {
+		t.Errorf("unexpected files %v", s.Files())
+	}
+
+	a2 := lexer.NewFile("a.l", []byte("{ }"))
+	s.Add(a2)
+	s.Remove("b.l")
+	if files := s.Files(); len(files) != 1 || files[0] != a2 {
+		t.Errorf("unexpected files %v", files)
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp // import "davidrjenni.io/lang/lsp"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/internal/errors"
+	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+// update analyzes the content of the document
+// and publishes the diagnostics.
+func (s *server) update(uri, text string) error {
+	if f := s.files.File(uri); f != nil {
+		delete(s.docs, f)
+	}
+	d := &document{text: text, file: lexer.NewFile(uri, []byte(text))}
+	s.files.Add(d.file)
+	s.docs[d.file] = d
+
+	b, comments, err := parser.ParseSource(d.file)
+	d.block, d.comments, d.syntax = b, comments, err != nil
+	var diags []Diagnostic
+	if err != nil {
+		diags = append(diags, diagnostics(err)...)
+	}
+	if b == nil {
+		return s.publish(uri, diags)
+	}
+
+	// The types are checked despite syntax errors, such that the
+	// valid parts can be navigated. Only the syntax errors are
+	// reported, since the other ones are likely caused by them.
+	info, err := types.Check(b)
+	d.info = info
+	if !d.syntax {
+		if err != nil {
+			diags = append(diags, diagnostics(err)...)
+		}
+		diags = append(diags, diagnostics(errors.Errors(info.Warnings))...)
+	}
+	return s.publish(uri, diags)
+}
+
+func (s *server) publish(uri string, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	params, err := json.Marshal(PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+	if err != nil {
+		return err
+	}
+	return s.conn.write(&message{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// diagnostics converts the errors of the parser or the type checker.
+func diagnostics(err error) []Diagnostic {
+	errs, ok := err.(errors.Errors)
+	if !ok {
+		errs = errors.Errors{err}
+	}
+	var diags []Diagnostic
+	for _, err := range errs {
+		d, ok := err.(*errors.Diagnostic)
+		if !ok {
+			diags = append(diags, Diagnostic{Severity: severityError, Source: "lang", Message: err.Error()})
+			continue
+		}
+		diag := Diagnostic{
+			Range:    toRange(d.Pos, d.End),
+			Severity: severityError,
+			Code:     d.Code.String(),
+			Source:   "lang",
+			Message:  d.Msg,
+		}
+		if d.Severity == errors.Warning {
+			diag.Severity = severityWarning
+		}
+		for _, n := range d.Notes {
+			diag.Message += "\nnote: " + n
+		}
+		for _, r := range d.Related {
+			diag.RelatedInformation = append(diag.RelatedInformation, DiagnosticRelatedInformation{
+				Location: toLocation(r.Pos, r.Pos),
+				Message:  r.Msg,
+			})
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// hover returns the type of the identifier or the
+// innermost expr at the position, or nil.
+func (s *server) hover(p TextDocumentPositionParams) (*Hover, error) {
+	d, off, err := s.docPos(p)
+	if err != nil {
+		return nil, err
+	}
+	if id, obj := d.identAt(off); id != nil {
+		text := obj.Type.String()
+		if _, ok := obj.Type.(*types.Builtin); !ok {
+			text = id.Name + " " + text
+		}
+		return &Hover{
+			Contents: MarkupContent{Kind: "plaintext", Value: text},
+			Range:    toRange(id.Pos(), id.End()),
+		}, nil
+	}
+
+	var inner *types.Object
+	for x, obj := range d.info.Types {
+		if contains(x, off) && (inner == nil || span(x) < span(inner.Node)) {
+			inner = obj
+		}
+	}
+	if inner == nil {
+		return nil, nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "plaintext", Value: inner.Type.String()},
+		Range:    toRange(inner.Node.Pos(), inner.Node.End()),
+	}, nil
+}
+
+// definition returns the location of the declaration of the
+// identifier at the position, or nil. Predeclared identifiers
+// have no declaration.
+func (s *server) definition(p TextDocumentPositionParams) (*Location, error) {
+	d, off, err := s.docPos(p)
+	if err != nil {
+		return nil, err
+	}
+	_, obj := d.identAt(off)
+	if obj == nil || origin(obj).Node == nil {
+		return nil, nil
+	}
+	n := origin(obj).Node
+	loc := toLocation(n.Pos(), n.End())
+	return &loc, nil
+}
+
+// references returns the locations of the identifiers, which
+// denote the same variable as the identifier at the position.
+func (s *server) references(p ReferenceParams) ([]Location, error) {
+	d, off, err := s.docPos(p.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+	locs := []Location{}
+	_, obj := d.identAt(off)
+	if obj == nil {
+		return locs, nil
+	}
+	for _, id := range d.refs(origin(obj)) {
+		if !p.Context.IncludeDeclaration && ast.Node(id) == origin(obj).Node {
+			continue
+		}
+		locs = append(locs, toLocation(id.Pos(), id.End()))
+	}
+	return locs, nil
+}
+
+// rename renames the variable denoted by the identifier at
+// the position. The new name must not be used in the document
+// already, since the variables must not shadow each other.
+func (s *server) rename(p RenameParams) (*WorkspaceEdit, error) {
+	d, off, err := s.docPos(p.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+	_, obj := d.identAt(off)
+	if obj == nil {
+		return nil, fmt.Errorf("no identifier at position")
+	}
+	if origin(obj).Node == nil {
+		return nil, fmt.Errorf("cannot rename predeclared identifier")
+	}
+	if !isIdent(p.NewName) {
+		return nil, fmt.Errorf("invalid identifier %q", p.NewName)
+	}
+	for id, o := range d.info.Uses {
+		if id.Name == p.NewName && origin(o) != origin(obj) {
+			return nil, fmt.Errorf("%s is already used at %s", p.NewName, id.Pos())
+		}
+	}
+
+	var edits []TextEdit
+	for _, id := range d.refs(origin(obj)) {
+		edits = append(edits, TextEdit{Range: toRange(id.Pos(), id.End()), NewText: p.NewName})
+	}
+	uri := d.file.Name()
+	return &WorkspaceEdit{Changes: map[string][]TextEdit{uri: edits}}, nil
+}
+
+// documentSymbols returns the symbols of the let-bound funcs.
+// The funcs declared in the body of a func are its children.
+func (s *server) documentSymbols(p DocumentSymbolParams) ([]DocumentSymbol, error) {
+	d, err := s.doc(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	syms := []DocumentSymbol{}
+	if d.block != nil {
+		syms = append(syms, d.symbols(d.block)...)
+	}
+	return syms, nil
+}
+
+func (d *document) symbols(cmd ast.Cmd) []DocumentSymbol {
+	var syms []DocumentSymbol
+	switch cmd := cmd.(type) {
+	case *ast.Block:
+		for _, c := range cmd.Cmds {
+			syms = append(syms, d.symbols(c)...)
+		}
+	case *ast.For:
+		syms = d.symbols(cmd.Block)
+	case *ast.If:
+		syms = d.symbols(cmd.Block)
+		if cmd.Else != nil {
+			syms = append(syms, d.symbols(cmd.Else.Cmd)...)
+		}
+	case *ast.VarDecl:
+		lit, ok := cmd.X.(*ast.FuncLit)
+		if !ok {
+			break
+		}
+		sym := DocumentSymbol{
+			Name:           cmd.Ident.Name,
+			Kind:           symbolFunction,
+			Range:          toRange(cmd.Pos(), cmd.End()),
+			SelectionRange: toRange(cmd.Ident.Pos(), cmd.Ident.End()),
+			Children:       d.symbols(lit.Block),
+		}
+		if obj, ok := d.info.Uses[cmd.Ident]; ok {
+			sym.Detail = obj.Type.String()
+		}
+		syms = append(syms, sym)
+	}
+	return syms
+}
+
+// format formats the document with the printer of lang fmt. The
+// result is a single edit, replacing the content of the document.
+func (s *server) format(p DocumentFormattingParams) ([]TextEdit, error) {
+	d, err := s.doc(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if d.syntax || d.block == nil {
+		return nil, fmt.Errorf("cannot format document with syntax errors")
+	}
+	var buf bytes.Buffer
+	if err := ast.Fprint(&buf, d.block, d.comments); err != nil {
+		return nil, err
+	}
+	edits := []TextEdit{}
+	if buf.String() != d.text {
+		edits = append(edits, TextEdit{
+			Range:   toRange(d.file.Pos(0), d.file.Pos(d.file.Size())),
+			NewText: buf.String(),
+		})
+	}
+	return edits, nil
+}
+
+func (s *server) doc(uri string) (*document, error) {
+	f := s.files.File(uri)
+	if f == nil {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+	return s.docs[f], nil
+}
+
+// docPos returns the document and the byte offset of the position.
+func (s *server) docPos(p TextDocumentPositionParams) (*document, int, error) {
+	d, err := s.doc(p.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, d.file.UTF16Pos(p.Position.Line+1, p.Position.Character+1).Offset(), nil
+}
+
+// identAt returns the identifier at the offset and its object. An
+// offset just after the identifier, e.g. of a cursor, is included.
+func (d *document) identAt(off int) (*ast.Ident, *types.Object) {
+	for id, obj := range d.info.Uses {
+		if id.Pos().Offset() <= off && off <= id.End().Offset() {
+			return id, obj
+		}
+	}
+	return nil, nil
+}
+
+// refs returns the identifiers denoting the object
+// or the variables narrowed from it, in source order.
+func (d *document) refs(obj *types.Object) []*ast.Ident {
+	var ids []*ast.Ident
+	for id, o := range d.info.Uses {
+		if origin(o) == obj {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos().Offset() < ids[j].Pos().Offset() })
+	return ids
+}
+
+// origin returns the variable, from which the object is narrowed.
+func origin(obj *types.Object) *types.Object {
+	for obj.Orig != nil {
+		obj = obj.Orig
+	}
+	return obj
+}
+
+// isIdent reports whether the name is an identifier.
+func isIdent(name string) bool {
+	l, err := lexer.New(strings.NewReader(name), "")
+	if err != nil {
+		return false
+	}
+	_, tok, lit, err := l.Read()
+	if err != nil || tok != lexer.Identifier || lit != name {
+		return false
+	}
+	_, tok, _, err = l.Read()
+	return err == nil && tok == lexer.EOF
+}
+
+func contains(n ast.Node, off int) bool {
+	return n.Pos().Offset() <= off && off < n.End().Offset()
+}
+
+func span(n ast.Node) int { return n.End().Offset() - n.Pos().Offset() }
+
+func toLocation(pos, end lexer.Pos) Location {
+	return Location{URI: pos.File().Name(), Range: toRange(pos, end)}
+}
+
+func toRange(pos, end lexer.Pos) Range {
+	return Range{Start: toPosition(pos), End: toPosition(end)}
+}
+
+func toPosition(p lexer.Pos) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	// The position may be shifted beyond the end of the file.
+	p = p.File().Pos(p.Offset())
+	return Position{Line: p.Line() - 1, Character: p.UTF16Column() - 1}
+}
@@ -0,0 +1,478 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"davidrjenni.io/lang/lsp"
+)
+
+const uri = "file:///src/input.l"
+
+const src = `{
+	let x := 1;
+	let add := func(a i64, b i64) i64 {
+		let inner := func() i64 {
+			return a;
+		};
+		return a + b + inner() + x;
+	};
+	println("😀", x);
+	if x > 0 {
+		let neg := func(n i64) i64 {
+			return -n;
+		};
+		println(add(neg(x), 2));
+	}
+}
+`
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	c.open(uri, "{\n\tlet y := z;\n}\n")
+	diags := c.diagnostics(uri)
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", diags)
+	}
+	expected := lsp.Diagnostic{
+		Range:    lsp.Range{Start: lsp.Position{Line: 1, Character: 10}, End: lsp.Position{Line: 1, Character: 11}},
+		Severity: 1,
+		Code:     "L003",
+		Source:   "lang",
+		Message:  "undefined identifer z",
+	}
+	if !reflect.DeepEqual(diags[0], expected) {
+		t.Errorf("expected diagnostic %+v, got %+v", expected, diags[0])
+	}
+
+	// Only the syntax errors are reported.
+	c.change(uri, "{\n\tlet := z;\n}\n")
+	if diags := c.diagnostics(uri); len(diags) != 1 || diags[0].Code != "L002" {
+		t.Errorf("expected a syntax error, got %+v", diags)
+	}
+
+	c.change(uri, src)
+	if diags := c.diagnostics(uri); len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diags)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	tests := [...]struct {
+		line, char int
+		expected   string
+	}{
+		{line: 1, char: 5, expected: "x i64"},
+		{line: 1, char: 10, expected: "i64"},
+		{line: 2, char: 6, expected: "add func(i64, i64) i64"},
+		{line: 8, char: 3, expected: "builtin println"},
+		{line: 8, char: 15, expected: "x i64"},
+		{line: 9, char: 6, expected: "bool"},
+	}
+	for _, test := range tests {
+		var h *lsp.Hover
+		c.call("textDocument/hover", position(test.line, test.char), &h)
+		if h == nil || h.Contents.Value != test.expected {
+			t.Errorf("%d:%d: expected %q, got %+v", test.line, test.char, test.expected, h)
+		}
+	}
+
+	var h *lsp.Hover
+	c.call("textDocument/hover", position(0, 0), &h)
+	if h != nil {
+		t.Errorf("expected no hover, got %+v", h)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	var loc *lsp.Location
+	c.call("textDocument/definition", position(8, 15), &loc)
+	expected := &lsp.Location{URI: uri, Range: span(1, 5, 6)}
+	if !reflect.DeepEqual(loc, expected) {
+		t.Errorf("expected %+v, got %+v", expected, loc)
+	}
+
+	loc = nil
+	c.call("textDocument/definition", position(8, 3), &loc)
+	if loc != nil {
+		t.Errorf("expected no definition of println, got %+v", loc)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	refs := []lsp.Location{
+		{URI: uri, Range: span(1, 5, 6)},
+		{URI: uri, Range: span(6, 27, 28)},
+		{URI: uri, Range: span(8, 15, 16)},
+		{URI: uri, Range: span(9, 4, 5)},
+		{URI: uri, Range: span(13, 18, 19)},
+	}
+	for _, decl := range []bool{true, false} {
+		params := map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"position":     lsp.Position{Line: 9, Character: 4},
+			"context":      map[string]bool{"includeDeclaration": decl},
+		}
+		var locs []lsp.Location
+		c.call("textDocument/references", params, &locs)
+		expected := refs
+		if !decl {
+			expected = refs[1:]
+		}
+		if !reflect.DeepEqual(locs, expected) {
+			t.Errorf("includeDeclaration %v: expected %+v, got %+v", decl, expected, locs)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	var edit lsp.WorkspaceEdit
+	c.call("textDocument/rename", rename(3, 7, "helper"), &edit)
+	expected := []lsp.TextEdit{
+		{Range: span(3, 6, 11), NewText: "helper"},
+		{Range: span(6, 17, 22), NewText: "helper"},
+	}
+	if !reflect.DeepEqual(edit.Changes[uri], expected) {
+		t.Errorf("expected %+v, got %+v", expected, edit.Changes[uri])
+	}
+
+	errs := [...]struct {
+		line, char int
+		name       string
+	}{
+		{line: 1, char: 5, name: "add"},
+		{line: 1, char: 5, name: "1x"},
+		{line: 8, char: 3, name: "show"},
+		{line: 0, char: 0, name: "y"},
+	}
+	for _, test := range errs {
+		if err := c.callErr("textDocument/rename", rename(test.line, test.char, test.name)); err == nil {
+			t.Errorf("%d:%d: expected error renaming to %s", test.line, test.char, test.name)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	var syms []lsp.DocumentSymbol
+	c.call("textDocument/documentSymbol", document(), &syms)
+	expected := []lsp.DocumentSymbol{
+		{
+			Name:           "add",
+			Detail:         "func(i64, i64) i64",
+			Kind:           12,
+			Range:          lsp.Range{Start: lsp.Position{Line: 2, Character: 1}, End: lsp.Position{Line: 7, Character: 2}},
+			SelectionRange: span(2, 5, 8),
+			Children: []lsp.DocumentSymbol{{
+				Name:           "inner",
+				Detail:         "func() i64",
+				Kind:           12,
+				Range:          lsp.Range{Start: lsp.Position{Line: 3, Character: 2}, End: lsp.Position{Line: 5, Character: 3}},
+				SelectionRange: span(3, 6, 11),
+			}},
+		},
+		{
+			Name:           "neg",
+			Detail:         "func(i64) i64",
+			Kind:           12,
+			Range:          lsp.Range{Start: lsp.Position{Line: 10, Character: 2}, End: lsp.Position{Line: 12, Character: 3}},
+			SelectionRange: span(10, 6, 9),
+		},
+	}
+	if !reflect.DeepEqual(syms, expected) {
+		t.Errorf("expected %+v, got %+v", expected, syms)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	c.open(uri, src)
+	var edits []lsp.TextEdit
+	c.call("textDocument/formatting", document(), &edits)
+	if len(edits) != 0 {
+		t.Errorf("expected no edits, got %+v", edits)
+	}
+
+	c.change(uri, "{ let x:=1 ;\n}")
+	c.call("textDocument/formatting", document(), &edits)
+	expected := []lsp.TextEdit{{
+		Range:   lsp.Range{End: lsp.Position{Line: 1, Character: 1}},
+		NewText: "{\n\tlet x := 1;\n}\n",
+	}}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("expected %+v, got %+v", expected, edits)
+	}
+
+	c.change(uri, "{ let x := ; }")
+	if err := c.callErr("textDocument/formatting", document()); err == nil {
+		t.Errorf("expected error formatting a document with syntax errors")
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	c := start(t)
+	if err := c.callErr("textDocument/hover", position(0, 0)); err == nil {
+		t.Errorf("expected error before initialize")
+	}
+	c.initialize()
+	if err := c.callErr("textDocument/codeLens", document()); err == nil {
+		t.Errorf("expected error calling unknown method")
+	}
+
+	c.open(uri, src)
+	c.notify("textDocument/didClose", document())
+	if err := c.callErr("textDocument/hover", position(0, 0)); err == nil {
+		t.Errorf("expected error for a closed document")
+	}
+	c.open(uri, src)
+	if err := c.callErr("textDocument/hover", position(0, 0)); err != nil {
+		t.Errorf("unexpected error for a reopened document: %v", err)
+	}
+	c.close()
+}
+
+type client struct {
+	t    *testing.T
+	w    io.WriteCloser
+	msgs chan map[string]json.RawMessage
+	done chan error
+	id   int
+
+	// diags contains the diagnostics published
+	// since the last call of diagnostics.
+	diags map[string][]lsp.Diagnostic
+}
+
+// newClient starts a server and initializes it.
+func newClient(t *testing.T) *client {
+	t.Helper()
+	c := start(t)
+	c.initialize()
+	return c
+}
+
+// start starts a server. The messages of the server are
+// read concurrently, such that the server never blocks.
+func start(t *testing.T) *client {
+	t.Helper()
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	c := &client{
+		t:     t,
+		w:     cw,
+		msgs:  make(chan map[string]json.RawMessage, 100),
+		done:  make(chan error, 1),
+		diags: make(map[string][]lsp.Diagnostic),
+	}
+	go func() {
+		c.done <- lsp.Serve(sr, sw)
+		sw.Close()
+	}()
+	go c.readLoop(bufio.NewReader(cr))
+	return c
+}
+
+func (c *client) initialize() {
+	c.t.Helper()
+	var result lsp.InitializeResult
+	c.call("initialize", map[string]interface{}{}, &result)
+	if !result.Capabilities.HoverProvider {
+		c.t.Fatalf("expected hover capability, got %+v", result)
+	}
+	c.notify("initialized", map[string]interface{}{})
+}
+
+func (c *client) readLoop(r *bufio.Reader) {
+	defer close(c.msgs)
+	for {
+		h, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(h.Get("Content-Length"))
+		if err != nil {
+			return
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			return
+		}
+		c.msgs <- m
+	}
+}
+
+func (c *client) send(m map[string]interface{}) {
+	c.t.Helper()
+	m["jsonrpc"] = "2.0"
+	body, err := json.Marshal(m)
+	if err != nil {
+		c.t.Fatalf("cannot marshal message: %v", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("cannot send message: %v", err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// request sends the request and waits for its response,
+// recording the diagnostics published in the meantime.
+func (c *client) request(method string, params interface{}) map[string]json.RawMessage {
+	c.t.Helper()
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	for m := range c.msgs {
+		if _, ok := m["id"]; !ok {
+			c.record(m)
+			continue
+		}
+		if string(m["id"]) != strconv.Itoa(c.id) {
+			c.t.Fatalf("%s: unexpected response %s", method, m["id"])
+		}
+		return m
+	}
+	c.t.Fatalf("%s: server closed the connection", method)
+	return nil
+}
+
+func (c *client) call(method string, params, result interface{}) {
+	c.t.Helper()
+	m := c.request(method, params)
+	if e, ok := m["error"]; ok {
+		c.t.Fatalf("%s: unexpected error %s", method, e)
+	}
+	if err := json.Unmarshal(m["result"], result); err != nil {
+		c.t.Fatalf("%s: cannot unmarshal result: %v", method, err)
+	}
+}
+
+func (c *client) callErr(method string, params interface{}) error {
+	c.t.Helper()
+	m := c.request(method, params)
+	e, ok := m["error"]
+	if !ok {
+		return nil
+	}
+	var err lsp.ResponseError
+	if jerr := json.Unmarshal(e, &err); jerr != nil {
+		c.t.Fatalf("%s: cannot unmarshal error: %v", method, jerr)
+	}
+	return &err
+}
+
+func (c *client) record(m map[string]json.RawMessage) {
+	c.t.Helper()
+	if string(m["method"]) != `"textDocument/publishDiagnostics"` {
+		return
+	}
+	var p lsp.PublishDiagnosticsParams
+	if err := json.Unmarshal(m["params"], &p); err != nil {
+		c.t.Fatalf("cannot unmarshal diagnostics: %v", err)
+	}
+	c.diags[p.URI] = p.Diagnostics
+}
+
+func (c *client) open(uri, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": lsp.TextDocumentItem{URI: uri, LanguageID: "lang", Version: 1, Text: text},
+	})
+}
+
+func (c *client) change(uri, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+}
+
+// diagnostics returns the diagnostics published for the document
+// up to now. A request is sent to wait for the notifications.
+func (c *client) diagnostics(uri string) []lsp.Diagnostic {
+	c.t.Helper()
+	delete(c.diags, uri)
+	var h *lsp.Hover
+	c.call("textDocument/hover", position(0, 0), &h)
+	diags, ok := c.diags[uri]
+	if !ok {
+		c.t.Fatalf("no diagnostics published for %s", uri)
+	}
+	return diags
+}
+
+// close shuts the server down and waits for it to exit.
+func (c *client) close() {
+	c.t.Helper()
+	var result interface{}
+	c.call("shutdown", nil, &result)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server failed: %v", err)
+	}
+	c.w.Close()
+}
+
+func document() map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]string{"uri": uri}}
+}
+
+func position(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     lsp.Position{Line: line, Character: char},
+	}
+}
+
+func rename(line, char int, name string) map[string]interface{} {
+	p := position(line, char)
+	p["newName"] = name
+	return p
+}
+
+func span(line, start, end int) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{Line: line, Character: start},
+		End:   lsp.Position{Line: line, Character: end},
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp // import "davidrjenni.io/lang/lsp"
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/types"
+)
+
+// Serve serves a client of the Language Server Protocol, which
+// sends its messages to r and receives the messages from w. The
+// documents are synced by their content and are analyzed on
+// every change. Serve returns, when the client sends the exit
+// notification or when r ends.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		conn: &conn{r: bufio.NewReader(r), w: w},
+		docs: make(map[*lexer.File]*document),
+	}
+	for {
+		m, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if m == nil {
+			return err
+		}
+		if err != nil {
+			// The message is not valid JSON, hence its id is unknown.
+			if err := s.conn.write(&message{Error: err.(*ResponseError)}); err != nil {
+				return err
+			}
+			continue
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	conn        *conn
+	initialized bool
+	shutdown    bool
+	files       lexer.FileSet             // files of the open documents, named by their URIs
+	docs        map[*lexer.File]*document // open documents by their files
+}
+
+// document is an open document with its analysis.
+type document struct {
+	text     string
+	file     *lexer.File // file named by the URI
+	block    *ast.Block  // nil, if the document cannot be read
+	comments []*ast.Comment
+	info     types.Info
+	syntax   bool // whether the document has syntax errors
+}
+
+// handle handles a request or a notification. Only
+// errors writing to the client are returned.
+func (s *server) handle(m *message) error {
+	if m.ID == nil {
+		return s.notify(m.Method, m.Params)
+	}
+
+	resp := &message{ID: m.ID}
+	result, err := s.call(m.Method, m.Params)
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		e, ok := err.(*ResponseError)
+		if !ok {
+			e = &ResponseError{Code: requestFailed, Message: err.Error()}
+		}
+		resp.Result, resp.Error = nil, e
+	}
+	return s.conn.write(resp)
+}
+
+// call calls the method of a request and returns its result.
+func (s *server) call(method string, params json.RawMessage) (interface{}, error) {
+	switch {
+	case method == "initialize":
+		s.initialized = true
+		var r InitializeResult
+		r.Capabilities = ServerCapabilities{
+			TextDocumentSync:           syncFull,
+			HoverProvider:              true,
+			DefinitionProvider:         true,
+			ReferencesProvider:         true,
+			RenameProvider:             true,
+			DocumentSymbolProvider:     true,
+			DocumentFormattingProvider: true,
+		}
+		r.ServerInfo.Name = "lang"
+		return r, nil
+	case !s.initialized:
+		return nil, &ResponseError{Code: serverNotInitialized, Message: "server not initialized"}
+	case s.shutdown:
+		return nil, &ResponseError{Code: invalidRequest, Message: "server is shut down"}
+	case method == "shutdown":
+		s.shutdown = true
+		return nil, nil
+	}
+
+	switch method {
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p)
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(p)
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.references(p)
+	case "textDocument/rename":
+		var p RenameParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.rename(p)
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(p)
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+		return s.format(p)
+	default:
+		return nil, &ResponseError{Code: methodNotFound, Message: fmt.Sprintf("method %s not found", method)}
+	}
+}
+
+// notify handles a notification. Unknown notifications are ignored.
+// Only errors writing to the client are returned.
+func (s *server) notify(method string, params json.RawMessage) error {
+	if !s.initialized || s.shutdown {
+		return nil
+	}
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if decode(params, &p) == nil {
+			return s.update(p.TextDocument.URI, p.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if decode(params, &p) == nil && len(p.ContentChanges) > 0 {
+			return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if decode(params, &p) == nil {
+			if f := s.files.File(p.TextDocument.URI); f != nil {
+				delete(s.docs, f)
+				s.files.Remove(f.Name())
+			}
+			return s.publish(p.TextDocument.URI, nil)
+		}
+	}
+	return nil
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: invalidParams, Message: err.Error()}
+	}
+	return nil
+}
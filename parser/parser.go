@@ -28,16 +28,64 @@ func Parse(r io.Reader, filename string) (*ast.Block, []*ast.Comment, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	p := &parser{l: l}
-	p.next()
+	return parse(l)
+}
+
+// ParseSource parses the source code of the file, e.g.
+// of a file, which is edited, but not yet saved.
+func ParseSource(f *lexer.File) (*ast.Block, []*ast.Comment, error) {
+	l, err := lexer.NewFromFile(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parse(l)
+}
+
+func parse(l *lexer.Lexer) (*ast.Block, []*ast.Comment, error) {
+	p := newParser(l)
 	n := p.parseBlock()
 	return n, p.comments, p.errs.Err()
 }
 
+// ParseExpr parses a single expr, e.g. an input of a REPL.
+func ParseExpr(r io.Reader, filename string) (ast.Expr, error) {
+	l, err := lexer.New(r, filename)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(l)
+	x := p.parseExpr()
+	p.expect(lexer.EOF)
+	return x, p.errs.Err()
+}
+
+// ParseCmds parses a sequence of cmds, which is not enclosed in
+// braces, e.g. an input of a REPL. The cmds are returned as block.
+func ParseCmds(r io.Reader, filename string) (*ast.Block, error) {
+	l, err := lexer.New(r, filename)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(l)
+	b := &ast.Block{StartPos: p.pos}
+	for p.tok != lexer.EOF {
+		b.Cmds = append(b.Cmds, p.parseCmd())
+	}
+	b.EndPos = p.pos
+	return b, p.errs.Err()
+}
+
+func newParser(l *lexer.Lexer) *parser {
+	p := &parser{l: l}
+	p.next()
+	return p
+}
+
 type parser struct {
 	l        *lexer.Lexer
 	errs     errors.Errors
 	comments []*ast.Comment
+	errPos   lexer.Pos // position of the last error
 
 	// lookahead
 	pos lexer.Pos
@@ -47,14 +95,19 @@ type parser struct {
 
 // ------- Declarations -------
 
-// VarDecl -> "let" Ident ":=" Expr ";" .
+// VarDecl -> "let" Ident [ Type ] ":=" Expr ";" .
 func (p *parser) parseVarDecl() *ast.VarDecl {
 	pos := p.expect(lexer.Let)
 	ident := p.parseIdent()
+	var t ast.Type
+	if p.tok != lexer.Define {
+		t = p.parseType()
+	}
+	spelling := p.lit
 	p.expect(lexer.Define)
 	x := p.parseExpr()
-	end := p.expect(lexer.Semicolon)
-	return &ast.VarDecl{Ident: ident, X: x, StartPos: pos, EndPos: end}
+	end := p.expectSemi()
+	return &ast.VarDecl{Ident: ident, Type: t, X: x, Spelling: spelling, StartPos: pos, EndPos: end}
 }
 
 // ------- Types -------
@@ -68,9 +121,31 @@ func (p *parser) parseTypes() (ts []ast.Type) {
 	return ts
 }
 
-// Type -> "bool" | "f64" | Func | "i64" | "string" .
+// Type -> BaseType { "|" BaseType } .
 func (p *parser) parseType() ast.Type {
+	t := p.parseBaseType()
+	if p.tok != lexer.Or {
+		return t
+	}
+	u := &ast.Union{Types: []ast.Type{t}}
+	for p.tok == lexer.Or {
+		u.Spellings = append(u.Spellings, p.lit)
+		p.next()
+		u.Types = append(u.Types, p.parseBaseType())
+	}
+	return u
+}
+
+// BaseType -> "(" Type ")" | Array | "bool" | "f64" | Func | "i64" | "string" .
+func (p *parser) parseBaseType() ast.Type {
 	switch p.tok {
+	case lexer.LeftParen:
+		p.expect(lexer.LeftParen)
+		t := p.parseType()
+		p.expect(lexer.RightParen)
+		return t
+	case lexer.LeftBracket:
+		return p.parseArray()
 	case lexer.Bool:
 		return p.parseScalar(lexer.Bool)
 	case lexer.F64:
@@ -82,11 +157,23 @@ func (p *parser) parseType() ast.Type {
 	case lexer.String:
 		return p.parseScalar(lexer.String)
 	default:
-		p.errs.Append(p.pos, "unexpected %s", p.lit)
-		return nil
+		p.errorf(errors.UnexpectedToken, "unexpected %s", p.lit)
+		return &ast.BadType{StartPos: p.pos, EndPos: p.pos}
 	}
 }
 
+// Array -> "[" [ I64Lit ] "]" BaseType .
+func (p *parser) parseArray() *ast.Array {
+	pos := p.expect(lexer.LeftBracket)
+	var n *ast.I64
+	if p.tok == lexer.I64Lit {
+		n = p.parseI64Lit()
+	}
+	p.expect(lexer.RightBracket)
+	elem := p.parseBaseType()
+	return &ast.Array{Len: n, Elem: elem, StartPos: pos}
+}
+
 // Func -> "func" "(" [ Types ] ")" Type .
 func (p *parser) parseFunc() *ast.Func {
 	pos := p.expect(lexer.Func)
@@ -113,14 +200,14 @@ func (p *parser) parseScalar(tok lexer.Tok) *ast.Scalar {
 func (p *parser) parseBlock() *ast.Block {
 	var b ast.Block
 	b.StartPos = p.expect(lexer.LeftBrace)
-	for p.in(cmdToks...) {
+	for !p.in(lexer.RightBrace, lexer.EOF) {
 		b.Cmds = append(b.Cmds, p.parseCmd())
 	}
 	b.EndPos = p.expect(lexer.RightBrace)
 	return &b
 }
 
-// Cmd -> Assert | Break | Continue | For | If | VarDecl | Return | Assign .
+// Cmd -> Assert | Break | Continue | ExprCmd | For | If | VarDecl | Return | Assign .
 func (p *parser) parseCmd() ast.Cmd {
 	switch p.tok {
 	case lexer.Assert:
@@ -131,6 +218,8 @@ func (p *parser) parseCmd() ast.Cmd {
 		return p.parseContinue()
 	case lexer.For:
 		return p.parseFor()
+	case lexer.Identifier, lexer.LeftParen:
+		return p.parseExprCmd()
 	case lexer.If:
 		return p.parseIf()
 	case lexer.Let:
@@ -140,8 +229,11 @@ func (p *parser) parseCmd() ast.Cmd {
 	case lexer.Set:
 		return p.parseAssign()
 	default:
-		p.errs.Append(p.pos, "unexpected %s", p.lit)
-		return nil
+		pos := p.pos
+		p.errorf(errors.UnexpectedToken, "unexpected %s", p.lit)
+		p.next()
+		p.sync()
+		return &ast.BadCmd{StartPos: pos, EndPos: p.pos}
 	}
 }
 
@@ -149,24 +241,31 @@ func (p *parser) parseCmd() ast.Cmd {
 func (p *parser) parseAssert() *ast.Assert {
 	pos := p.expect(lexer.Assert)
 	x := p.parseExpr()
-	end := p.expect(lexer.Semicolon)
+	end := p.expectSemi()
 	return &ast.Assert{X: x, StartPos: pos, EndPos: end}
 }
 
 // Break -> "break" ";" .
 func (p *parser) parseBreak() *ast.Break {
 	pos := p.expect(lexer.Break)
-	end := p.expect(lexer.Semicolon)
+	end := p.expectSemi()
 	return &ast.Break{StartPos: pos, EndPos: end}
 }
 
 // Continue -> "continue" ";" .
 func (p *parser) parseContinue() *ast.Continue {
 	pos := p.expect(lexer.Continue)
-	end := p.expect(lexer.Semicolon)
+	end := p.expectSemi()
 	return &ast.Continue{StartPos: pos, EndPos: end}
 }
 
+// ExprCmd -> Expr ";" .
+func (p *parser) parseExprCmd() *ast.ExprCmd {
+	x := p.parseExpr()
+	end := p.expectSemi()
+	return &ast.ExprCmd{X: x, EndPos: end}
+}
+
 // For -> "for" Expr Block .
 func (p *parser) parseFor() *ast.For {
 	pos := p.expect(lexer.For)
@@ -191,7 +290,7 @@ func (p *parser) parseIf() *ast.If {
 		case lexer.If:
 			cmd = p.parseCmd()
 		default:
-			p.errs.Append(p.pos, "unexpected %s, expected %s or %s", p.lit, lexer.LeftBrace, lexer.If)
+			p.errorf(errors.UnexpectedToken, "unexpected %s, expected %s or %s", p.lit, lexer.LeftBrace, lexer.If)
 		}
 		if cmd != nil {
 			e = &ast.Else{Cmd: cmd, StartPos: pos}
@@ -204,23 +303,33 @@ func (p *parser) parseIf() *ast.If {
 func (p *parser) parseReturn() *ast.Return {
 	pos := p.expect(lexer.Return)
 	x := p.parseExpr()
-	end := p.expect(lexer.Semicolon)
+	end := p.expectSemi()
 	return &ast.Return{X: x, StartPos: pos, EndPos: end}
 }
 
-// Assign -> "set" Ident "<-" Expr ";" .
+// Assign -> "set" PrimaryExpr "<-" Expr ";" .
 func (p *parser) parseAssign() *ast.Assign {
 	pos := p.expect(lexer.Set)
-	ident := p.parseIdent()
+	lhs := p.parsePrimaryExpr()
+	spelling := p.lit
 	p.expect(lexer.Assign)
 	x := p.parseExpr()
-	end := p.expect(lexer.Semicolon)
-	return &ast.Assign{Ident: ident, X: x, StartPos: pos, EndPos: end}
+	end := p.expectSemi()
+	return &ast.Assign{LHS: lhs, X: x, Spelling: spelling, StartPos: pos, EndPos: end}
 }
 
 // ------- Expressions -------
 
-// Expr -> UnaryExpr { BinOp UnaryExpr } .
+// Exprs -> Expr { "," Expr } .
+func (p *parser) parseExprs() (xs []ast.Expr) {
+	xs = append(xs, p.parseExpr())
+	for p.got(lexer.Comma) {
+		xs = append(xs, p.parseExpr())
+	}
+	return xs
+}
+
+// Expr -> UnaryExpr { BinOp UnaryExpr | "is" BaseType } .
 func (p *parser) parseExpr() ast.Expr {
 	var (
 		parseMul = func() ast.Expr { return p.parseBinaryExpr(p.parseUnaryExpr, lexer.Multiply, lexer.Divide) }
@@ -235,10 +344,16 @@ func (p *parser) parseExpr() ast.Expr {
 func (p *parser) parseBinaryExpr(parse func() ast.Expr, ops ...lexer.Tok) ast.Expr {
 	x := parse()
 	for p.in(ops...) {
-		op := p.tok
+		op, spelling := p.tok, p.lit
 		p.next()
+		if op == lexer.Is {
+			// The type must not be a union, since
+			// "|" is ambiguous with the operator.
+			x = &ast.IsExpr{X: x, Type: p.parseBaseType()}
+			continue
+		}
 		y := parse()
-		x = &ast.BinaryExpr{LHS: x, Op: op, RHS: y}
+		x = &ast.BinaryExpr{LHS: x, Op: op, RHS: y, Spelling: spelling}
 	}
 	return x
 }
@@ -247,20 +362,35 @@ func (p *parser) parseBinaryExpr(parse func() ast.Expr, ops ...lexer.Tok) ast.Ex
 func (p *parser) parseUnaryExpr() ast.Expr {
 	switch p.tok {
 	case lexer.Minus, lexer.Not:
-		op := p.tok
+		op, spelling := p.tok, p.lit
 		pos := p.expect(lexer.Minus, lexer.Not)
 		x := p.parseUnaryExpr()
-		return &ast.UnaryExpr{X: x, Op: op, StartPos: pos}
+		return &ast.UnaryExpr{X: x, Op: op, Spelling: spelling, StartPos: pos}
 	default:
 		return p.parsePrimaryExpr()
 	}
 }
 
-// PrimaryExpr -> ParenExpr | F64Lit | FuncLit | I64Lit | Identifier | StringLit | True | False .
+// PrimaryExpr -> Operand { CallExpr | IndexExpr } .
 func (p *parser) parsePrimaryExpr() ast.Expr {
+	x := p.parseOperand()
+	for p.in(lexer.LeftParen, lexer.LeftBracket) {
+		if p.tok == lexer.LeftParen {
+			x = p.parseCallExpr(x)
+		} else {
+			x = p.parseIndexExpr(x)
+		}
+	}
+	return x
+}
+
+// Operand -> ParenExpr | ArrayLit | F64Lit | FuncLit | I64Lit | Identifier | StringLit | True | False .
+func (p *parser) parseOperand() ast.Expr {
 	switch p.tok {
 	case lexer.LeftParen:
 		return p.parseParenExpr()
+	case lexer.LeftBracket:
+		return p.parseArrayLit()
 	case lexer.F64Lit:
 		return p.parseF64Lit()
 	case lexer.Func:
@@ -276,9 +406,28 @@ func (p *parser) parsePrimaryExpr() ast.Expr {
 	case lexer.False:
 		return p.parseFalse()
 	default:
-		p.errs.Append(p.pos, "unexpected %s", p.lit)
-		return nil
+		p.errorf(errors.UnexpectedToken, "unexpected %s", p.lit)
+		return &ast.BadExpr{StartPos: p.pos, EndPos: p.pos}
+	}
+}
+
+// CallExpr -> "(" [ Exprs ] ")" .
+func (p *parser) parseCallExpr(fn ast.Expr) *ast.CallExpr {
+	p.expect(lexer.LeftParen)
+	var args []ast.Expr
+	if p.tok != lexer.RightParen {
+		args = p.parseExprs()
 	}
+	end := p.expect(lexer.RightParen)
+	return &ast.CallExpr{Func: fn, Args: args, EndPos: end}
+}
+
+// IndexExpr -> "[" Expr "]" .
+func (p *parser) parseIndexExpr(x ast.Expr) *ast.IndexExpr {
+	p.expect(lexer.LeftBracket)
+	index := p.parseExpr()
+	end := p.expect(lexer.RightBracket)
+	return &ast.IndexExpr{X: x, Index: index, EndPos: end}
 }
 
 // ParenExpr -> "(" Expr ")" .
@@ -289,6 +438,18 @@ func (p *parser) parseParenExpr() *ast.ParenExpr {
 	return &ast.ParenExpr{X: x, StartPos: pos, EndPos: end}
 }
 
+// ArrayLit -> Array "{" [ Exprs ] "}" .
+func (p *parser) parseArrayLit() *ast.ArrayLit {
+	t := p.parseArray()
+	p.expect(lexer.LeftBrace)
+	var elems []ast.Expr
+	if p.tok != lexer.RightBrace {
+		elems = p.parseExprs()
+	}
+	end := p.expect(lexer.RightBrace)
+	return &ast.ArrayLit{Type: t, Elems: elems, EndPos: end}
+}
+
 func (p *parser) parseF64Lit() *ast.F64 {
 	lit := p.lit
 	pos := p.expect(lexer.F64Lit)
@@ -369,11 +530,11 @@ func (p *parser) next() {
 		var err error
 		p.pos, p.tok, p.lit, err = p.l.Read()
 		if err != nil {
-			p.errs.Append(p.pos, "syntax error: %v", err)
+			p.errorf(errors.InvalidToken, "syntax error: %v", err)
 			continue
 		}
 		if p.tok == lexer.Illegal {
-			p.errs.Append(p.pos, "syntax error: %s", p.lit)
+			p.errorf(errors.InvalidToken, "syntax error: %s", p.lit)
 			continue
 		}
 		if p.tok == lexer.Comment {
@@ -411,7 +572,7 @@ func (p *parser) expect(toks ...lexer.Tok) lexer.Pos {
 		return pos
 	}
 	if len(toks) == 1 {
-		p.errs.Append(p.pos, "unexpected %s, expected %s", p.lit, toks[0])
+		p.errorf(errors.UnexpectedToken, "unexpected %s, expected %s", p.lit, toks[0])
 		return pos
 	}
 	var b bytes.Buffer
@@ -421,10 +582,42 @@ func (p *parser) expect(toks ...lexer.Tok) lexer.Pos {
 		}
 		b.WriteString(tok.String())
 	}
-	p.errs.Append(p.pos, "unexpected %s, expected one of %s", p.lit, b.String())
+	p.errorf(errors.UnexpectedToken, "unexpected %s, expected one of %s", p.lit, b.String())
 	return pos
 }
 
+// expectSemi expects the semicolon terminating a cmd. Otherwise,
+// the tokens up to the end of the cmd are skipped.
+func (p *parser) expectSemi() lexer.Pos {
+	pos := p.pos
+	if p.got(lexer.Semicolon) {
+		return pos
+	}
+	p.errorf(errors.UnexpectedToken, "unexpected %s, expected %s", p.lit, lexer.Semicolon)
+	p.sync()
+	return pos
+}
+
+// sync skips the tokens up to and including the next semicolon,
+// or up to the next right brace or keyword starting a cmd, such
+// that parsing continues after a syntax error.
+func (p *parser) sync() {
+	for !p.in(syncToks...) {
+		p.next()
+	}
+	p.got(lexer.Semicolon)
+}
+
+// errorf records an error at the current token, unless there
+// is already one at the same position, which caused it.
+func (p *parser) errorf(code errors.Code, format string, args ...interface{}) {
+	if len(p.errs) > 0 && p.pos == p.errPos {
+		return
+	}
+	p.errPos = p.pos
+	p.errs.Append(code, p.pos, p.pos.Shift(len(p.lit)), format, args...)
+}
+
 var (
 	relOps = []lexer.Tok{
 		lexer.Less,
@@ -437,14 +630,17 @@ var (
 		lexer.Is,
 	}
 
-	cmdToks = []lexer.Tok{
+	syncToks = []lexer.Tok{
 		lexer.Assert,
 		lexer.Break,
 		lexer.Continue,
+		lexer.EOF,
 		lexer.For,
 		lexer.If,
 		lexer.Let,
 		lexer.Return,
+		lexer.RightBrace,
+		lexer.Semicolon,
 		lexer.Set,
 	}
 )
@@ -9,6 +9,7 @@ import (
 	"flag"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"davidrjenni.io/lang/ast"
@@ -47,3 +48,35 @@ func TestParse(t *testing.T) {
 		t.Fatalf("expected\n%s\ngot\n%s\n", string(expected), actual.String())
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	tests := [...]struct {
+		src      string
+		expected string
+	}{
+		{src: `{ let x := ; }`, expected: "1:12: unexpected ;"},
+		{
+			src:      `{ let x := ; let y := 1 + ; set z <- 2; }`,
+			expected: "1:12: unexpected ;\n1:27: unexpected ;",
+		},
+		{
+			src:      `{ 1; let x i64 := 2 let y := x; if x { } else ) }`,
+			expected: "1:3: unexpected 1\n1:21: unexpected let, expected ;\n1:47: unexpected ), expected { or if",
+		},
+		{
+			src:      `{ let f := func(a) i64 { return a }; f(1) }`,
+			expected: "1:18: unexpected )\n1:35: unexpected }, expected ;\n1:43: unexpected }, expected ;",
+		},
+		{src: `{ let a := [2]i64{1, ; }`, expected: "1:22: unexpected ;"},
+		{src: `{ println(1);`, expected: "1:14: unexpected EOF, expected }"},
+	}
+
+	for _, test := range tests {
+		n, _, err := parser.Parse(strings.NewReader(test.src), "")
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: expected errors\n%s\ngot\n%v", test.src, test.expected, err)
+		}
+		// The partial tree must not contain nil nodes.
+		ast.Dump(ioutil.Discard, n)
+	}
+}
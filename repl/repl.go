@@ -0,0 +1,234 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repl // import "davidrjenni.io/lang/repl"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/internal/errors"
+	"davidrjenni.io/lang/interp"
+	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/lexer"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+)
+
+// filename is the filename of the inputs reported by diagnostics.
+const filename = "repl"
+
+const (
+	prompt     = "> "
+	contPrompt = "... "
+)
+
+const help = `Enter cmds, e.g. let x := 1;, or exprs, whose values are printed.
+Inputs with unbalanced braces are continued on the following lines.
+
+Commands:
+    :ast input  print the syntax tree of the input
+    :help       print this help
+    :ir [cmds]  print the ir of the session, followed by the cmds
+    :quit       end the session, like EOF
+    :type expr  print the type of the expr
+`
+
+// Run reads the inputs from r until EOF or :quit and writes the
+// prompts, the results and the diagnostics to out. The variables
+// declared by an input remain defined for the following inputs.
+func Run(r io.Reader, out io.Writer) error {
+	s := &session{
+		out:    out,
+		types:  types.NewSession(),
+		interp: interp.NewSession(out),
+	}
+	sc := bufio.NewScanner(r)
+	var input strings.Builder
+	lines := 0 // number of the lines read
+	for !s.quit {
+		if input.Len() == 0 {
+			fmt.Fprint(out, prompt)
+		} else {
+			fmt.Fprint(out, contPrompt)
+		}
+		if !sc.Scan() {
+			fmt.Fprintln(out)
+			break
+		}
+		lines++
+		input.WriteString(sc.Text())
+		input.WriteByte('\n')
+		if depth(input.String()) > 0 {
+			continue
+		}
+		s.eval(input.String())
+		input.Reset()
+		s.line = lines
+	}
+	return sc.Err()
+}
+
+// depth returns the number of unclosed braces in the input.
+func depth(input string) int {
+	l, err := lexer.New(strings.NewReader(input), filename)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for {
+		_, tok, _, err := l.Read()
+		if err != nil {
+			continue
+		}
+		switch tok {
+		case lexer.LeftBrace:
+			n++
+		case lexer.RightBrace:
+			n--
+		case lexer.EOF:
+			return n
+		}
+	}
+}
+
+type session struct {
+	out     io.Writer
+	types   *types.Session
+	interp  *interp.Session
+	history []ast.Cmd // cmds of the executed inputs
+	line    int       // number of the lines before the current input
+	quit    bool      // whether the session is ended
+}
+
+// source returns the source code of the current input. It is
+// preceded by a newline for each line before the input, such
+// that the positions refer to the lines of the session.
+func (s *session) source(input string) io.Reader {
+	return strings.NewReader(strings.Repeat("\n", s.line) + input)
+}
+
+func (s *session) eval(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+	if !strings.HasPrefix(input, ":") {
+		if x, err := parser.ParseExpr(s.source(input), filename); err == nil {
+			s.evalExpr(x)
+			return
+		}
+		s.exec(input)
+		return
+	}
+
+	cmd, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case ":ast":
+		s.dumpAST(arg)
+	case ":help":
+		fmt.Fprint(s.out, help)
+	case ":ir":
+		s.dumpIR(arg)
+	case ":quit":
+		s.quit = true
+	case ":type":
+		x, err := parser.ParseExpr(s.source(arg), filename)
+		if err != nil {
+			errors.Print(s.out, err)
+			return
+		}
+		t, _, err := s.types.CheckExpr(x)
+		if err != nil {
+			errors.Print(s.out, err)
+			return
+		}
+		fmt.Fprintln(s.out, t)
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, see :help\n", cmd)
+	}
+}
+
+// evalExpr prints the value and the type of the expr.
+func (s *session) evalExpr(x ast.Expr) {
+	t, info, err := s.types.CheckExpr(x)
+	if err != nil {
+		errors.Print(s.out, err)
+		return
+	}
+	val, err := s.interp.Eval(x, info)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	if _, ok := t.(*types.Void); !ok {
+		fmt.Fprintf(s.out, "%s : %s\n", val, t)
+	}
+}
+
+// exec executes the cmds of the input. If the execution
+// fails, the declarations of the input are discarded.
+func (s *session) exec(input string) {
+	b, err := parser.ParseCmds(s.source(input), filename)
+	if err != nil {
+		errors.Print(s.out, err)
+		return
+	}
+	info, err := s.types.Check(b)
+	if err != nil {
+		errors.Print(s.out, err)
+		return
+	}
+	if len(info.Warnings) > 0 {
+		errors.Print(s.out, errors.Errors(info.Warnings))
+	}
+	if err := s.interp.Exec(b, info); err != nil {
+		fmt.Fprintln(s.out, err)
+		s.types.Undo()
+		return
+	}
+	s.history = append(s.history, b.Cmds...)
+}
+
+// dumpAST prints the syntax tree of the expr or cmds.
+func (s *session) dumpAST(input string) {
+	var n ast.Node
+	if x, err := parser.ParseExpr(s.source(input), filename); err == nil {
+		n = x
+	} else {
+		b, err := parser.ParseCmds(s.source(input), filename)
+		if err != nil {
+			errors.Print(s.out, err)
+			return
+		}
+		n = b
+	}
+	ast.Dump(s.out, n)
+	fmt.Fprintln(s.out)
+}
+
+// dumpIR prints the ir of the executed inputs followed by the
+// cmds, which are checked, but neither executed nor retained.
+func (s *session) dumpIR(input string) {
+	b, err := parser.ParseCmds(s.source(input), filename)
+	if err != nil {
+		errors.Print(s.out, err)
+		return
+	}
+	info, err := s.types.Check(b)
+	if err != nil {
+		errors.Print(s.out, err)
+		return
+	}
+	s.types.Undo()
+
+	cmds := append(s.history[:len(s.history):len(s.history)], b.Cmds...)
+	for _, f := range ir.Translate(&ast.Block{Cmds: cmds}, info) {
+		ir.Dump(s.out, f)
+	}
+}
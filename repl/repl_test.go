@@ -0,0 +1,47 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repl_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"davidrjenni.io/lang/repl"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRun(t *testing.T) {
+	f, err := os.Open(filepath.Join("test-fixtures", "input.repl"))
+	if err != nil {
+		t.Fatalf("cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	var actual bytes.Buffer
+	if err := repl.Run(f, &actual); err != nil {
+		t.Fatalf("cannot run: %v", err)
+	}
+
+	golden := filepath.Join("test-fixtures", "input.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, actual.Bytes(), 0644); err != nil {
+			t.Fatalf("cannot update golden file: %v", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("cannot read golden file: %v", err)
+	}
+
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Fatalf("expected\n%s\ngot\n%s\n", string(expected), actual.String())
+	}
+}
@@ -6,26 +6,41 @@ package types // import "davidrjenni.io/lang/types"
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"davidrjenni.io/lang/ast"
 	"davidrjenni.io/lang/internal/errors"
 	"davidrjenni.io/lang/lexer"
 )
 
+// maxParams is the maximum number of params of a func,
+// such that all args can be passed in registers.
+const maxParams = 6
+
 func Check(b *ast.Block) (Info, error) {
-	c := &checker{
-		scope: &scope{objects: make(map[string]*Object)},
+	c := newChecker()
+	c.checkCmd(b)
+	c.Warnings = c.warns
+	return c.Info, c.errs.Err()
+}
+
+func newChecker() *checker {
+	return &checker{
+		scope: universe.enter(),
 		Info: Info{
-			Uses:  make(map[*ast.Ident]*Object),
-			Types: make(map[ast.Expr]*Object),
+			Uses:       make(map[*ast.Ident]*Object),
+			Types:      make(map[ast.Expr]*Object),
+			Captures:   make(map[*ast.FuncLit][]*Object),
+			Narrowings: make(map[*ast.If][]*Object),
+			Tested:     make(map[*ast.IsExpr]Type),
 		},
 	}
-	c.checkCmd(b)
-	return c.Info, c.errs.Err()
 }
 
 type checker struct {
 	errs  errors.Errors
+	warns errors.Errors
 	scope *scope
 	Info
 }
@@ -38,33 +53,70 @@ func (c *checker) checkCmd(n ast.Cmd) {
 			return
 		}
 		if _, ok := t.(*Bool); !ok {
-			c.errorf(n.X.Pos(), "expr must be of type bool, got %s", t)
+			c.errorf(n.X, errors.NonBoolCond, "expr must be of type bool, got %s", t)
 		}
 	case *ast.Assign:
-		lhs, ok := c.scope.lookup(n.Ident.Name)
-		if !ok {
-			c.errorf(n.Pos(), "undefined identifer %s", n.Ident.Name)
+		var lhs Type
+		switch x := n.LHS.(type) {
+		case *ast.Ident:
+			obj, ok := c.use(x)
+			if !ok {
+				c.errorf(n, errors.UndefinedIdent, "undefined identifer %s", x.Name)
+				return
+			}
+			if obj.Orig != nil {
+				c.errorf(n, errors.InvalidAssign, "cannot assign to %s narrowed to %s", x.Name, obj.Type).
+					Relate(obj.Node.Pos(), "%s narrowed here", x.Name).
+					Note("narrowed variables are copies, hence they cannot be assigned")
+				return
+			}
+			lhs = obj.Type
+		case *ast.IndexExpr:
+			t, ok := c.checkExpr(x)
+			if !ok {
+				return
+			}
+			lhs = t
+		case *ast.BadExpr:
+			return
+		default:
+			c.errorf(n.LHS, errors.InvalidAssign, "cannot assign to expr")
 			return
 		}
 		rhs, ok := c.checkExpr(n.X)
 		if !ok {
 			return
 		}
-		if !Equal(lhs.Type, rhs) {
-			c.errorf(n.Pos(), "cannot assign expr of type %s to variable of type %s", rhs, lhs.Type)
+		if !AssignableTo(rhs, lhs) {
+			c.errorf(n, errors.IncompatibleTypes, "cannot assign expr of type %s to variable of type %s", rhs, lhs)
 		}
-		c.Uses[n.Ident] = lhs
+	case *ast.BadCmd:
 	case *ast.Block:
-		for _, cmd := range n.Cmds {
+		// The unreachable code is reported before the cmds
+		// within it, such that the warnings are in source order.
+		unreachable := false
+		for i, cmd := range n.Cmds {
+			if !unreachable && i > 0 && terminates(n.Cmds[i-1]) {
+				c.warnf(cmd, errors.UnreachableCode, "unreachable code")
+				unreachable = true
+			}
 			c.checkCmd(cmd)
 		}
 	case *ast.Break:
 		if !c.scope.inFor {
-			c.errorf(n.Pos(), "break must be in for loop")
+			c.errorf(n, errors.MisplacedCmd, "break must be in for loop")
 		}
 	case *ast.Continue:
 		if !c.scope.inFor {
-			c.errorf(n.Pos(), "continue must be in for loop")
+			c.errorf(n, errors.MisplacedCmd, "continue must be in for loop")
+		}
+	case *ast.ExprCmd:
+		switch unparen(n.X).(type) {
+		case *ast.BadExpr:
+		case *ast.CallExpr:
+			c.checkExpr(n.X)
+		default:
+			c.errorf(n.X, errors.UnusedExpr, "expr is not used")
 		}
 	case *ast.For:
 		t, ok := c.checkExpr(n.X)
@@ -72,7 +124,7 @@ func (c *checker) checkCmd(n ast.Cmd) {
 			return
 		}
 		if _, ok := t.(*Bool); !ok {
-			c.errorf(n.X.Pos(), "expr must be of type bool, got %s", t)
+			c.errorf(n.X, errors.NonBoolCond, "expr must be of type bool, got %s", t)
 		}
 		c.scope = c.scope.enter()
 		c.scope.inFor = true
@@ -84,9 +136,10 @@ func (c *checker) checkCmd(n ast.Cmd) {
 			return
 		}
 		if _, ok := t.(*Bool); !ok {
-			c.errorf(n.X.Pos(), "expr must be of type bool, got %s", t)
+			c.errorf(n.X, errors.NonBoolCond, "expr must be of type bool, got %s", t)
 		}
 		c.scope = c.scope.enter()
+		c.narrow(n)
 		c.checkCmd(n.Block)
 		c.scope = c.scope.parent
 		if n.Else != nil {
@@ -96,21 +149,38 @@ func (c *checker) checkCmd(n ast.Cmd) {
 		}
 	case *ast.Return:
 		if c.scope.func_ == nil {
-			c.errorf(n.Pos(), "unexpected return cmd outside of func scope")
+			c.errorf(n, errors.MisplacedCmd, "unexpected return cmd outside of func scope")
 			return
 		}
 		t, ok := c.checkExpr(n.X)
 		if !ok {
 			return
 		}
-		if !Equal(t, c.scope.func_.Result) {
-			c.errorf(n.Pos(), "cannot return expr of type %s, expected expr of type %s", t, c.scope.func_.Result)
+		if !AssignableTo(t, c.scope.func_.Result) {
+			c.errorf(n, errors.IncompatibleTypes, "cannot return expr of type %s, expected expr of type %s", t, c.scope.func_.Result)
 			return
 		}
 	case *ast.VarDecl:
-		if t, ok := c.checkExpr(n.X); ok {
-			c.insert(n.Ident, t)
+		t, ok := c.checkExpr(n.X)
+		if !ok {
+			return
 		}
+		if _, ok := t.(*Void); ok {
+			c.errorf(n.X, errors.VoidValue, "expr of type %s used as value", t)
+			return
+		}
+		if n.Type != nil {
+			declared, ok := c.checkType(n.Type)
+			if !ok {
+				return
+			}
+			if !AssignableTo(t, declared) {
+				c.errorf(n.X, errors.IncompatibleTypes, "cannot use expr of type %s as value of type %s", t, declared)
+				return
+			}
+			t = declared
+		}
+		c.insert(n.Ident, t)
 	default:
 		panic(fmt.Sprintf("unexpected type %T", n))
 	}
@@ -124,19 +194,33 @@ func (c *checker) checkExpr(x ast.Expr) (t Type, ok bool) {
 	}()
 
 	switch x := x.(type) {
+	case *ast.ArrayLit:
+		return c.checkArrayLit(x)
+	case *ast.BadExpr:
+		// The syntax error is already reported.
+		return nil, false
 	case *ast.BinaryExpr:
 		return c.checkBinaryExpr(x)
 	case *ast.Bool:
 		return &Bool{}, true
+	case *ast.CallExpr:
+		return c.checkCallExpr(x)
 	case *ast.I64:
 		return &I64{}, true
 	case *ast.Ident:
-		if obj, ok := c.scope.lookup(x.Name); ok {
-			c.Uses[x] = obj
+		if obj, ok := c.use(x); ok {
+			if _, ok := obj.Type.(*Builtin); ok {
+				c.errorf(x, errors.UncalledBuiltin, "%s must be called", x.Name)
+				return nil, false
+			}
 			return obj.Type, true
 		}
-		c.errorf(x.Pos(), "undefined identifer %s", x.Name)
+		c.errorf(x, errors.UndefinedIdent, "undefined identifer %s", x.Name)
 		return nil, false
+	case *ast.IndexExpr:
+		return c.checkIndexExpr(x)
+	case *ast.IsExpr:
+		return c.checkIsExpr(x)
 	case *ast.F64:
 		return &F64{}, true
 	case *ast.FuncLit:
@@ -152,6 +236,51 @@ func (c *checker) checkExpr(x ast.Expr) (t Type, ok bool) {
 	}
 }
 
+func (c *checker) checkArrayLit(l *ast.ArrayLit) (Type, bool) {
+	t, ok := c.checkType(l.Type)
+	if !ok {
+		return nil, false
+	}
+	elem := elemType(t)
+
+	ok = true
+	for _, x := range l.Elems {
+		et, elemOK := c.checkExpr(x)
+		if !elemOK {
+			ok = false
+			continue
+		}
+		if !AssignableTo(et, elem) {
+			c.errorf(x, errors.IncompatibleTypes, "cannot use expr of type %s as elem of type %s", et, elem)
+			ok = false
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	a, ok := t.(*Array)
+	if !ok {
+		return t, true
+	}
+	n := int64(len(l.Elems))
+	if n > a.Len {
+		c.errorf(l, errors.InvalidArrayLit, "too many elems for array of type %s", a)
+		return nil, false
+	}
+	// Missing elems are zeroed, which is
+	// only a valid value for some types.
+	switch elem.(type) {
+	case *Bool, *F64, *I64:
+	default:
+		if n < a.Len {
+			c.errorf(l, errors.InvalidArrayLit, "array literal of type %s must have %d elems", a, a.Len)
+			return nil, false
+		}
+	}
+	return a, true
+}
+
 func (c *checker) checkBinaryExpr(x *ast.BinaryExpr) (Type, bool) {
 	lhs, ok := c.checkExpr(x.LHS)
 	if !ok {
@@ -161,12 +290,15 @@ func (c *checker) checkBinaryExpr(x *ast.BinaryExpr) (Type, bool) {
 	if !ok {
 		return nil, false
 	}
+	if x.Op == lexer.In {
+		return c.checkIn(x, lhs, rhs)
+	}
 	if !Equal(lhs, rhs) {
-		c.errorf(x.Pos(), "cannot apply %s to operands of types %s and %s", x.Op, lhs, rhs)
+		c.errorf(x, errors.InvalidOp, "cannot apply %s to operands of types %s and %s", x.Op, lhs, rhs)
 		return nil, false
 	}
 
-	switch t := lhs.(type) {
+	switch lhs.(type) {
 	case *Bool:
 		if lexer.And <= x.Op && x.Op <= lexer.Implies {
 			return &Bool{}, true
@@ -195,19 +327,213 @@ func (c *checker) checkBinaryExpr(x *ast.BinaryExpr) (Type, bool) {
 		if lexer.Less <= x.Op && x.Op <= lexer.GreaterEq {
 			return &Bool{}, true
 		}
+	}
+
+	c.errorf(x, errors.InvalidOp, "cannot apply %s to operands of types %s and %s", x.Op, lhs, rhs)
+	return nil, false
+}
+
+func (c *checker) checkIndexExpr(x *ast.IndexExpr) (Type, bool) {
+	t, ok := c.checkExpr(x.X)
+	if !ok {
+		return nil, false
+	}
+	i, ok := c.checkExpr(x.Index)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := i.(*I64); !ok {
+		c.errorf(x.Index, errors.InvalidIndex, "index must be of type i64, got %s", i)
+		return nil, false
+	}
+
+	switch t := t.(type) {
+	case *Array:
+		if l, ok := x.Index.(*ast.I64); ok {
+			if n, err := parseI64(l); err == nil && n >= t.Len {
+				c.errorf(x.Index, errors.InvalidIndex, "index %s out of range for array of type %s", l.Val, t)
+				return nil, false
+			}
+		}
+		return t.Elem, true
+	case *Slice:
+		return t.Elem, true
 	default:
-		panic(fmt.Sprintf("unexpected type %T", t))
+		c.errorf(x.X, errors.InvalidIndex, "cannot index expr of type %s", t)
+		return nil, false
 	}
+}
 
-	c.errorf(x.Pos(), "cannot apply %s to operands of types %s and %s", x.Op, lhs, rhs)
+// checkIn checks x ∈ xs, which is either the membership
+// of a scalar in an array or a substring of a string.
+func (c *checker) checkIn(x *ast.BinaryExpr, lhs, rhs Type) (Type, bool) {
+	if _, ok := rhs.(*String); ok && Equal(lhs, rhs) {
+		return &Bool{}, true
+	}
+	if elem := elemType(rhs); elem != nil && Equal(lhs, elem) {
+		switch elem.(type) {
+		case *Bool, *F64, *I64, *String:
+			return &Bool{}, true
+		}
+	}
+	c.errorf(x, errors.InvalidOp, "cannot apply %s to operands of types %s and %s", x.Op, lhs, rhs)
 	return nil, false
 }
 
+func (c *checker) checkIsExpr(x *ast.IsExpr) (Type, bool) {
+	t, ok := c.checkExpr(x.X)
+	if !ok {
+		return nil, false
+	}
+	is, ok := c.checkType(x.Type)
+	if !ok {
+		return nil, false
+	}
+	u, ok := t.(*Union)
+	if !ok {
+		c.errorf(x.X, errors.InvalidIsExpr, "expr must be of a union type, got %s", t)
+		return nil, false
+	}
+	if !u.Contains(is) {
+		c.errorf(x.Type, errors.InvalidIsExpr, "expr of type %s cannot be of type %s", u, is)
+		return nil, false
+	}
+	c.Tested[x] = is
+	return &Bool{}, true
+}
+
+// narrow narrows the variables tested in the conjuncts of the
+// condition of the if cmd to the tested types in the current scope.
+// The narrowed variables are copies, hence they cannot be assigned.
+func (c *checker) narrow(n *ast.If) {
+	for _, x := range conjuncts(n.X) {
+		is, ok := x.(*ast.IsExpr)
+		if !ok {
+			continue
+		}
+		t, ok := c.Tested[is]
+		if !ok {
+			continue
+		}
+		id, ok := unparen(is.X).(*ast.Ident)
+		if !ok {
+			continue
+		}
+		orig := c.Uses[id]
+		if _, ok := c.scope.objects[id.Name]; ok {
+			// The variable is tested more than once.
+			continue
+		}
+		obj := &Object{Node: id, Type: t, Orig: orig}
+		c.scope.objects[id.Name] = obj
+		c.Narrowings[n] = append(c.Narrowings[n], obj)
+	}
+}
+
+// conjuncts returns the operands of the conjunction x.
+func conjuncts(x ast.Expr) []ast.Expr {
+	x = unparen(x)
+	if b, ok := x.(*ast.BinaryExpr); ok && b.Op == lexer.And {
+		return append(conjuncts(b.LHS), conjuncts(b.RHS)...)
+	}
+	return []ast.Expr{x}
+}
+
+func (c *checker) checkCallExpr(x *ast.CallExpr) (Type, bool) {
+	if id, ok := x.Func.(*ast.Ident); ok {
+		if obj, _, ok := c.scope.lookup(id.Name); ok {
+			if b, ok := obj.Type.(*Builtin); ok {
+				c.use(id)
+				c.Types[id] = &Object{Type: b, Node: id}
+				return c.checkBuiltinCall(x, b)
+			}
+		}
+	}
+
+	t, ok := c.checkExpr(x.Func)
+	if !ok {
+		return nil, false
+	}
+	f, ok := t.(*Func)
+	if !ok {
+		c.errorf(x.Func, errors.InvalidCall, "cannot call expr of type %s", t)
+		return nil, false
+	}
+	if len(x.Args) != len(f.Params) {
+		c.errorf(x, errors.InvalidCall, "cannot call func of type %s with %d args", f, len(x.Args))
+		return nil, false
+	}
+
+	ok = true
+	for i, a := range x.Args {
+		t, argOK := c.checkExpr(a)
+		if !argOK {
+			ok = false
+			continue
+		}
+		if !AssignableTo(t, f.Params[i]) {
+			c.errorf(a, errors.IncompatibleTypes, "cannot use expr of type %s as arg of type %s", t, f.Params[i])
+			ok = false
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return f.Result, true
+}
+
+// checkBuiltinCall checks a call of a predeclared func. The
+// func len takes an array, print and println take any number
+// of args of scalar types.
+func (c *checker) checkBuiltinCall(x *ast.CallExpr, b *Builtin) (Type, bool) {
+	if b.Name == "len" {
+		if len(x.Args) != 1 {
+			c.errorf(x, errors.InvalidCall, "cannot call len with %d args", len(x.Args))
+			return nil, false
+		}
+		t, ok := c.checkExpr(x.Args[0])
+		if !ok {
+			return nil, false
+		}
+		if elemType(t) == nil {
+			c.errorf(x.Args[0], errors.InvalidCall, "cannot take len of expr of type %s", t)
+			return nil, false
+		}
+		return &I64{}, true
+	}
+
+	ok := true
+	for _, a := range x.Args {
+		t, argOK := c.checkExpr(a)
+		if !argOK {
+			ok = false
+			continue
+		}
+		switch t.(type) {
+		case *Bool, *F64, *I64, *String:
+		default:
+			c.errorf(a, errors.InvalidCall, "cannot %s expr of type %s", b.Name, t)
+			ok = false
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return &Void{}, true
+}
+
 func (c *checker) checkFuncLit(f *ast.FuncLit) (Type, bool) {
 	defer func() {
 		c.scope = c.scope.parent
 	}()
 	c.scope = c.scope.enter()
+	c.scope.lit = f
+	c.scope.inFor = false
+
+	if len(f.Params) > maxParams {
+		c.errorf(f, errors.TooManyParams, "func must not have more than %d params", maxParams)
+		return nil, false
+	}
 
 	params := make([]Type, 0, len(f.Params))
 	for _, p := range f.Params {
@@ -228,6 +554,9 @@ func (c *checker) checkFuncLit(f *ast.FuncLit) (Type, bool) {
 	c.scope.func_ = t
 
 	c.checkCmd(f.Block)
+	if !terminates(f.Block) {
+		c.errs.Append(errors.MissingReturn, f.Block.End(), f.Block.End(), "missing return")
+	}
 	return t, true
 }
 
@@ -240,36 +569,74 @@ func (c *checker) checkUnaryExpr(x *ast.UnaryExpr) (Type, bool) {
 	switch t := t.(type) {
 	case *Bool:
 		if x.Op != lexer.Not {
-			c.errorf(x.Pos(), "cannot apply %s to expr of type %s", x.Op, t)
+			c.errorf(x, errors.InvalidOp, "cannot apply %s to expr of type %s", x.Op, t)
 			return nil, false
 		}
 		return &Bool{}, true
 	case *I64:
 		if x.Op != lexer.Minus {
-			c.errorf(x.Pos(), "cannot apply %s to expr of type %s", x.Op, t)
+			c.errorf(x, errors.InvalidOp, "cannot apply %s to expr of type %s", x.Op, t)
 			return nil, false
 		}
 		return &I64{}, true
 	case *F64:
 		if x.Op != lexer.Minus {
-			c.errorf(x.Pos(), "cannot apply %s to expr of type %s", x.Op, t)
+			c.errorf(x, errors.InvalidOp, "cannot apply %s to expr of type %s", x.Op, t)
 			return nil, false
 		}
 		return &F64{}, true
 	default:
-		c.errorf(x.Pos(), "cannot apply %s to expr of type %s", x.Op, t)
+		c.errorf(x, errors.InvalidOp, "cannot apply %s to expr of type %s", x.Op, t)
 		return nil, false
 	}
 }
 
 func (c *checker) checkType(t ast.Type) (Type, bool) {
 	switch t := t.(type) {
+	case *ast.Array:
+		elem, ok := c.checkType(t.Elem)
+		if !ok {
+			return nil, false
+		}
+		if t.Len == nil {
+			return &Slice{Elem: elem}, true
+		}
+		n, err := parseI64(t.Len)
+		if err != nil {
+			c.errorf(t.Len, errors.InvalidArrayLen, "invalid array length %s", t.Len.Val)
+			return nil, false
+		}
+		return &Array{Len: n, Elem: elem}, true
+	case *ast.BadType:
+		return nil, false
 	case *ast.Func:
+		if len(t.Params) > maxParams {
+			c.errorf(t, errors.TooManyParams, "func must not have more than %d params", maxParams)
+			return nil, false
+		}
+		params := make([]Type, 0, len(t.Params))
+		for _, p := range t.Params {
+			pt, ok := c.checkType(p)
+			if !ok {
+				return nil, false
+			}
+			params = append(params, pt)
+		}
 		result, ok := c.checkType(t.Result)
 		if !ok {
 			return nil, false
 		}
-		return &Func{Result: result}, true
+		return &Func{Params: params, Result: result}, true
+	case *ast.Union:
+		ts := make([]Type, 0, len(t.Types))
+		for _, u := range t.Types {
+			ut, ok := c.checkType(u)
+			if !ok {
+				return nil, false
+			}
+			ts = append(ts, ut)
+		}
+		return NewUnion(ts...), true
 	case *ast.Scalar:
 		switch t.Name {
 		case "bool":
@@ -288,9 +655,46 @@ func (c *checker) checkType(t ast.Type) (Type, bool) {
 	}
 }
 
+// use looks up the object of the given identifier and records
+// its use. If the object is defined outside of the enclosing
+// func literals, it is recorded as captured by them.
+func (c *checker) use(id *ast.Ident) (*Object, bool) {
+	obj, def, ok := c.scope.lookup(id.Name)
+	if !ok {
+		return nil, false
+	}
+	c.Uses[id] = obj
+	if def == universe {
+		return obj, true
+	}
+
+	for s := c.scope; s != def; s = s.parent {
+		// Only the outermost scope of a func literal,
+		// which is not the one of the definition.
+		if s.lit == def.lit || s.lit == s.parent.lit {
+			continue
+		}
+		if !contains(c.Captures[s.lit], obj) {
+			c.Captures[s.lit] = append(c.Captures[s.lit], obj)
+		}
+	}
+	return obj, true
+}
+
+func contains(objs []*Object, obj *Object) bool {
+	for _, o := range objs {
+		if o == obj {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *checker) insert(id *ast.Ident, t Type) {
-	if obj, ok := c.scope.lookup(id.Name); ok {
-		c.errorf(id.Pos(), "%s already defined at %s", id.Name, obj.Node.Pos())
+	// Predeclared objects may be shadowed.
+	if obj, def, ok := c.scope.lookup(id.Name); ok && def != universe {
+		c.errorf(id, errors.AlreadyDefined, "%s already defined at %s", id.Name, obj.Node.Pos()).
+			Relate(obj.Node.Pos(), "previous definition of %s", id.Name)
 		return
 	}
 	obj := &Object{Node: id, Type: t}
@@ -298,6 +702,85 @@ func (c *checker) insert(id *ast.Ident, t Type) {
 	c.scope.objects[id.Name] = obj
 }
 
-func (c *checker) errorf(pos lexer.Pos, format string, args ...interface{}) {
-	c.errs.Append(pos, format, args...)
+// elemType returns the elem type of an array or slice, or nil.
+func elemType(t Type) Type {
+	switch t := t.(type) {
+	case *Array:
+		return t.Elem
+	case *Slice:
+		return t.Elem
+	default:
+		return nil
+	}
+}
+
+// terminates reports whether the cmd never completes normally,
+// i.e. the cmds following it are unreachable. A block terminates if
+// one of its cmds terminates. A for loop with the condition true and
+// without break and an assert of false terminate.
+func terminates(n ast.Cmd) bool {
+	switch n := n.(type) {
+	case *ast.Assert:
+		return isBool(n.X, "false")
+	case *ast.Block:
+		for _, cmd := range n.Cmds {
+			if terminates(cmd) {
+				return true
+			}
+		}
+		return false
+	case *ast.Break, *ast.Continue, *ast.Return:
+		return true
+	case *ast.For:
+		return isBool(n.X, "true") && !breaks(n.Block)
+	case *ast.If:
+		return n.Else != nil && terminates(n.Block) && terminates(n.Else.Cmd)
+	default:
+		return false
+	}
+}
+
+// breaks reports whether the cmd contains a break
+// of the enclosing for loop.
+func breaks(n ast.Cmd) bool {
+	switch n := n.(type) {
+	case *ast.Block:
+		for _, cmd := range n.Cmds {
+			if breaks(cmd) {
+				return true
+			}
+		}
+	case *ast.Break:
+		return true
+	case *ast.If:
+		return breaks(n.Block) || (n.Else != nil && breaks(n.Else.Cmd))
+	}
+	return false
+}
+
+// isBool reports whether x is the bool literal val.
+func isBool(x ast.Expr, val string) bool {
+	b, ok := unparen(x).(*ast.Bool)
+	return ok && b.Val == val
+}
+
+func parseI64(l *ast.I64) (int64, error) {
+	return strconv.ParseInt(strings.ReplaceAll(l.Val, "_", ""), 10, 64)
+}
+
+func unparen(x ast.Expr) ast.Expr {
+	if p, ok := x.(*ast.ParenExpr); ok {
+		return unparen(p.X)
+	}
+	return x
+}
+
+func (c *checker) errorf(n ast.Node, code errors.Code, format string, args ...interface{}) *errors.Diagnostic {
+	return c.errs.Append(code, n.Pos(), n.End(), format, args...)
+}
+
+func (c *checker) warnf(n ast.Node, code errors.Code, format string, args ...interface{}) *errors.Diagnostic {
+	d := c.warns.Append(code, n.Pos(), n.End(), format, args...)
+	d.Severity = errors.Warning
+	return d
 }
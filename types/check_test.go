@@ -5,9 +5,14 @@
 package types_test
 
 import (
+	"fmt"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
+	"davidrjenni.io/lang/ast"
 	"davidrjenni.io/lang/parser"
 	"davidrjenni.io/lang/types"
 )
@@ -23,3 +28,160 @@ func TestCheck(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestCaptures(t *testing.T) {
+	const src = `{
+	let a := 1;
+	let b := 2;
+	let f := func(x i64) func() i64 {
+		let c := x + a;
+		return func() i64 {
+			set b <- b + c;
+			return x + b;
+		};
+	};
+}`
+	n, _, err := parser.Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+
+	info, err := types.Check(n)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var actual []string
+	for lit, objs := range info.Captures {
+		var names []string
+		for _, obj := range objs {
+			names = append(names, obj.Node.(*ast.Ident).Name)
+		}
+		actual = append(actual, fmt.Sprintf("%s: %s", lit.Pos(), strings.Join(names, ", ")))
+	}
+	sort.Strings(actual)
+
+	expected := []string{
+		"4:11: a, b",
+		"6:10: b, c, x",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := [...]struct {
+		src      string
+		expected string
+	}{
+		{src: `{ let p := print; }`, expected: "1:12: print must be called"},
+		{src: `{ let x := println(1); }`, expected: "1:12: expr of type void used as value"},
+		{src: `{ println(func() i64 { return 1; }); }`, expected: "1:11: cannot println expr of type func() i64"},
+		{src: `{ let x := 1; (x + 2); }`, expected: "1:15: expr is not used"},
+		{src: `{ let a := [2]i64{1, 2, 3}; }`, expected: "1:12: too many elems for array of type [2]i64"},
+		{src: `{ let a := [2]string{"x"}; }`, expected: "1:12: array literal of type [2]string must have 2 elems"},
+		{src: `{ let a := [2]i64{}; let x := a[2]; }`, expected: "1:33: index 2 out of range for array of type [2]i64"},
+		{src: `{ let a := []i64{}; let x := a[true]; }`, expected: "1:32: index must be of type i64, got bool"},
+		{src: `{ let a := []i64{}; let b := [1]i64{}; set b <- a; }`, expected: "1:40: cannot assign expr of type []i64 to variable of type [1]i64"},
+		{src: `{ let x := len(1); }`, expected: "1:16: cannot take len of expr of type i64"},
+		{src: `{ let x := 1 ∈ []f64{1.0}; }`, expected: "1:12: cannot apply ∈ to operands of types i64 and []f64"},
+		{src: `{ let x := 1 ∈ 1; }`, expected: "1:12: cannot apply ∈ to operands of types i64 and i64"},
+		{src: `{ let x := 1; let y := x is i64; }`, expected: "1:24: expr must be of a union type, got i64"},
+		{src: `{ let x i64 | bool := 1; let y := x is f64; }`, expected: "1:40: expr of type i64 | bool cannot be of type f64"},
+		{src: `{ let x i64 | bool := "s"; }`, expected: "1:23: cannot use expr of type string as value of type i64 | bool"},
+		{src: `{ let x i64 | bool := 1; if x is i64 { set x <- 2; } }`, expected: "1:40: cannot assign to x narrowed to i64"},
+		{src: `{ let f := func(x i64) i64 { if x > 0 { return 1; } }; }`, expected: "1:53: missing return"},
+		{src: `{ let f := func(x i64) i64 { if x > 0 { return 1; } else if x < 0 { return 2; } }; }`, expected: "1:81: missing return"},
+		{src: `{ let f := func() i64 { for true { if true { break; } } }; }`, expected: "1:57: missing return"},
+		{src: `{ let f := func() i64 { assert true; }; }`, expected: "1:38: missing return"},
+		{src: `{ let f := func(x i64) i64 { return x; }; let y := f(1, 2); }`, expected: "1:52: cannot call func of type func(i64) i64 with 2 args"},
+		{src: `{ let f := func(x i64) i64 { return x; }; let y := f(true); }`, expected: "1:54: cannot use expr of type bool as arg of type i64"},
+		{src: `{ let x := 1; let y := x(1); }`, expected: "1:24: cannot call expr of type i64"},
+		{src: `{ let f func(i64, i64, i64, i64, i64, i64, i64) i64 := func(x i64) i64 { return x; }; }`, expected: "1:9: func must not have more than 6 params"},
+	}
+
+	for _, test := range tests {
+		n, _, err := parser.Parse(strings.NewReader(test.src), "")
+		if err != nil {
+			t.Fatalf("cannot parse %q: %v", test.src, err)
+		}
+		_, err = types.Check(n)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: expected error %q, got %v", test.src, test.expected, err)
+		}
+	}
+}
+
+func TestCheckSyntaxErrors(t *testing.T) {
+	tests := [...]struct {
+		src      string
+		expected string
+	}{
+		{src: `{ let x := 1 + ; println(x); }`, expected: "1:26: undefined identifer x"},
+		{src: `{ let x := 1; set x <- (; x; }`, expected: "1:27: expr is not used"},
+		{src: `{ 1; set ) <- 2; let y i64 | := 1; }`},
+		{src: `{ let a := [2]i64{1, ; }`},
+	}
+
+	for _, test := range tests {
+		n, _, err := parser.Parse(strings.NewReader(test.src), "")
+		if err == nil {
+			t.Fatalf("%q: expected syntax errors", test.src)
+		}
+		_, err = types.Check(n)
+		if test.expected == "" && err != nil {
+			t.Errorf("%q: expected no errors, got %v", test.src, err)
+		}
+		if test.expected != "" && (err == nil || err.Error() != test.expected) {
+			t.Errorf("%q: expected error %q, got %v", test.src, test.expected, err)
+		}
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	tests := [...]struct {
+		src      string
+		expected []string
+	}{
+		{src: `{ let x := 1; println(x); }`},
+		{src: `{ for true { break; println(1); } }`, expected: []string{"1:21: unreachable code"}},
+		{src: `{ for true { continue; println(1); println(2); } }`, expected: []string{"1:24: unreachable code"}},
+		{
+			src:      `{ let f := func() i64 { if true { return 1; } else { return 2; } return 3; }; }`,
+			expected: []string{"1:66: unreachable code"},
+		},
+		{src: `{ let f := func() i64 { if true { return 1; } return 2; }; }`},
+		{src: `{ let f := func(x i64) i64 { if x > 0 { return 1; } else if x < 0 { return 2; } else { return 3; } }; }`},
+		{src: `{ let f := func() i64 { for true { for true { break; } } }; }`},
+		{src: `{ let f := func() i64 { assert false; }; }`},
+		{src: `{ let f := func() i64 { for (true) { } return 1; }; }`, expected: []string{"1:40: unreachable code"}},
+		{
+			src:      `{ for true { if true { break; } else { continue; } let x := 1; } let f := func() i64 { return 1; return 2; }; }`,
+			expected: []string{"1:52: unreachable code", "1:98: unreachable code"},
+		},
+		{
+			// The warnings are in source order, despite the nesting.
+			src:      `{ let f := func() i64 { return 1; let g := func() i64 { return 2; return 3; }; return 4; }; }`,
+			expected: []string{"1:35: unreachable code", "1:67: unreachable code"},
+		},
+	}
+
+	for _, test := range tests {
+		n, _, err := parser.Parse(strings.NewReader(test.src), "")
+		if err != nil {
+			t.Fatalf("cannot parse %q: %v", test.src, err)
+		}
+		info, err := types.Check(n)
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		var actual []string
+		for _, w := range info.Warnings {
+			actual = append(actual, w.Error())
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected warnings %q, got %q", test.src, test.expected, actual)
+		}
+	}
+}
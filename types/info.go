@@ -9,11 +9,42 @@ import "davidrjenni.io/lang/ast"
 type Object struct {
 	Node ast.Node
 	Type Type
+
+	// Orig is the variable, which is narrowed to the type
+	// of the object in the block guarded by an is-expr.
+	Orig *Object
 }
 
 type Info struct {
 	Uses  map[*ast.Ident]*Object
 	Types map[ast.Expr]*Object
+
+	// Captures maps func literals to the objects of the enclosing
+	// funcs they use, in the order of their first use. A func literal
+	// also captures the objects captured by nested func literals.
+	Captures map[*ast.FuncLit][]*Object
+
+	// Narrowings maps if cmds to the objects of the variables,
+	// which are narrowed in their block. An if cmd narrows the
+	// variable x of a union type in the conjuncts x is T of its
+	// condition.
+	Narrowings map[*ast.If][]*Object
+
+	// Tested maps is-exprs to their tested types.
+	Tested map[*ast.IsExpr]Type
+
+	// Warnings contains the problems, which do not prevent
+	// the program from being compiled, e.g. unreachable code.
+	Warnings []error
+}
+
+// universe is the scope of the predeclared objects.
+var universe = &scope{
+	objects: map[string]*Object{
+		"len":     {Type: &Builtin{Name: "len"}},
+		"print":   {Type: &Builtin{Name: "print"}},
+		"println": {Type: &Builtin{Name: "println"}},
+	},
 }
 
 type scope struct {
@@ -21,6 +52,7 @@ type scope struct {
 
 	objects map[string]*Object
 	func_   *Func
+	lit     *ast.FuncLit
 	inFor   bool
 }
 
@@ -29,14 +61,17 @@ func (s *scope) enter() *scope {
 		parent:  s,
 		objects: make(map[string]*Object),
 		func_:   s.func_,
+		lit:     s.lit,
 		inFor:   s.inFor,
 	}
 }
 
-func (s *scope) lookup(name string) (*Object, bool) {
+// lookup returns the object with the given name
+// and the scope in which the object is defined.
+func (s *scope) lookup(name string) (*Object, *scope, bool) {
 	obj, ok := s.objects[name]
 	if !ok && s.parent != nil {
 		return s.parent.lookup(name)
 	}
-	return obj, ok
+	return obj, s, ok
 }
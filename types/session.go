@@ -0,0 +1,57 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types // import "davidrjenni.io/lang/types"
+
+import "davidrjenni.io/lang/ast"
+
+// Session checks the inputs of an interactive session, e.g. of
+// a REPL. The variables declared by an input remain in scope for
+// the following inputs. Each input is checked in its own scope,
+// such that the declarations of an invalid input are discarded.
+type Session struct {
+	c    *checker
+	prev *scope // scope before the last input
+}
+
+func NewSession() *Session {
+	return &Session{c: newChecker()}
+}
+
+// Check checks the cmds of the block as the next input. The
+// returned info covers all the inputs checked so far, such
+// that it describes the objects declared by earlier inputs.
+func (s *Session) Check(b *ast.Block) (Info, error) {
+	return s.check(func() { s.c.checkCmd(b) })
+}
+
+// CheckExpr checks the expr as the next input and returns its type.
+func (s *Session) CheckExpr(x ast.Expr) (Type, Info, error) {
+	var t Type
+	info, err := s.check(func() {
+		t, _ = s.c.checkExpr(x)
+	})
+	return t, info, err
+}
+
+func (s *Session) check(check func()) (Info, error) {
+	s.c.errs, s.c.warns = nil, nil
+	s.prev = s.c.scope
+	s.c.scope = s.c.scope.enter()
+	check()
+	s.c.Warnings = s.c.warns
+	if err := s.c.errs.Err(); err != nil {
+		s.Undo()
+		return s.c.Info, err
+	}
+	return s.c.Info, nil
+}
+
+// Undo discards the declarations of the last input,
+// e.g. if its execution failed.
+func (s *Session) Undo() {
+	if s.prev != nil {
+		s.c.scope, s.prev = s.prev, nil
+	}
+}
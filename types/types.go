@@ -17,9 +17,15 @@ type Type interface {
 
 func Equal(t, u Type) bool {
 	switch t := t.(type) {
+	case *Array:
+		a, ok := u.(*Array)
+		return ok && t.Len == a.Len && Equal(t.Elem, a.Elem)
 	case *Bool:
 		_, ok := u.(*Bool)
 		return ok
+	case *Builtin:
+		b, ok := u.(*Builtin)
+		return ok && t.Name == b.Name
 	case *F64:
 		_, ok := u.(*F64)
 		return ok
@@ -40,14 +46,57 @@ func Equal(t, u Type) bool {
 	case *I64:
 		_, ok := u.(*I64)
 		return ok
+	case *Slice:
+		sl, ok := u.(*Slice)
+		return ok && Equal(t.Elem, sl.Elem)
+	case *Union:
+		v, ok := u.(*Union)
+		return ok && len(t.Types) == len(v.Types) && t.subsetOf(v)
 	case *String:
 		_, ok := u.(*String)
 		return ok
+	case *Void:
+		_, ok := u.(*Void)
+		return ok
 	default:
 		panic(fmt.Sprintf("unexpected type %T", t))
 	}
 }
 
+// AssignableTo reports whether a value of type t can be used as
+// a value of type u. An array can be used as a slice of its elems
+// and a value can be used as a union, which contains its type.
+func AssignableTo(t, u Type) bool {
+	if a, ok := t.(*Array); ok {
+		if sl, ok := u.(*Slice); ok {
+			return Equal(a.Elem, sl.Elem)
+		}
+	}
+	if v, ok := u.(*Union); ok {
+		if w, ok := t.(*Union); ok {
+			return w.subsetOf(v)
+		}
+		return v.Contains(t)
+	}
+	return Equal(t, u)
+}
+
+// Array is a fixed-size array type. Arrays and slices are
+// references to their length followed by their elems.
+type Array struct {
+	Len  int64
+	Elem Type
+}
+
+func (a *Array) String() string { return fmt.Sprintf("[%d]%s", a.Len, elemString(a.Elem)) }
+
+// Builtin is the type of a predeclared func.
+type Builtin struct {
+	Name string
+}
+
+func (b *Builtin) String() string { return "builtin " + b.Name }
+
 type Func struct {
 	Params []Type
 	Result Type
@@ -66,26 +115,121 @@ func (f *Func) String() string {
 	return b.String()
 }
 
+// Union is a sum type, whose values are of one of its types.
+// A union consists of at least two types, none of them a union.
+// Values of unions are references to the tag of their type
+// followed by the value.
+type Union struct {
+	Types []Type
+}
+
+// NewUnion returns the union of the given types. Nested unions
+// are flattened and duplicate types are removed. If only one type
+// remains, it is returned instead.
+func NewUnion(ts ...Type) Type {
+	u := &Union{}
+	for _, t := range ts {
+		if v, ok := t.(*Union); ok {
+			for _, t := range v.Types {
+				u.add(t)
+			}
+			continue
+		}
+		u.add(t)
+	}
+	if len(u.Types) == 1 {
+		return u.Types[0]
+	}
+	return u
+}
+
+func (u *Union) add(t Type) {
+	if !u.Contains(t) {
+		u.Types = append(u.Types, t)
+	}
+}
+
+// Contains reports whether t is one of the types of the union.
+func (u *Union) Contains(t Type) bool {
+	for _, v := range u.Types {
+		if Equal(t, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Union) subsetOf(v *Union) bool {
+	for _, t := range u.Types {
+		if !v.Contains(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *Union) String() string {
+	b := &bytes.Buffer{}
+	for i, t := range u.Types {
+		if i > 0 {
+			b.WriteString(" | ")
+		}
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+// elemString returns the string of the elem type of an
+// array, which is parenthesized, if it is a union.
+func elemString(t Type) string {
+	if _, ok := t.(*Union); ok {
+		return "(" + t.String() + ")"
+	}
+	return t.String()
+}
+
+// Slice is a slice-like array type, whose length
+// is only known at run time.
+type Slice struct {
+	Elem Type
+}
+
+func (s *Slice) String() string { return "[]" + elemString(s.Elem) }
+
 type (
 	Bool   struct{}
 	F64    struct{}
 	I64    struct{}
 	String struct{}
+
+	// Void is the type of calls without a result.
+	Void struct{}
 )
 
-func (*Bool) Size() int   { return 1 }
-func (*F64) Size() int    { return 8 }
-func (*Func) Size() int   { return 8 }
-func (*I64) Size() int    { return 8 }
-func (*String) Size() int { return 8 }
+func (*Array) Size() int   { return 8 }
+func (*Bool) Size() int    { return 1 }
+func (*Builtin) Size() int { return 0 }
+func (*F64) Size() int     { return 8 }
+func (*Func) Size() int    { return 8 }
+func (*I64) Size() int     { return 8 }
+func (*Slice) Size() int   { return 8 }
+func (*Union) Size() int   { return 8 }
+func (*String) Size() int  { return 8 }
+func (*Void) Size() int    { return 0 }
 
 func (*Bool) String() string   { return "bool" }
 func (*F64) String() string    { return "f64" }
 func (*I64) String() string    { return "i64" }
 func (*String) String() string { return "string" }
+func (*Void) String() string   { return "void" }
 
-func (*Bool) typ()   {}
-func (*F64) typ()    {}
-func (*Func) typ()   {}
-func (*I64) typ()    {}
-func (*String) typ() {}
+func (*Array) typ()   {}
+func (*Bool) typ()    {}
+func (*Builtin) typ() {}
+func (*F64) typ()     {}
+func (*Func) typ()    {}
+func (*I64) typ()     {}
+func (*Slice) typ()   {}
+func (*Union) typ()   {}
+func (*String) typ()  {}
+func (*Void) typ()    {}
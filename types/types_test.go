@@ -42,6 +42,11 @@ func TestEqual(t *testing.T) {
 		{t: &types.Func{Params: []types.Type{&types.I64{}}, Result: &types.Bool{}}, u: &types.Func{Params: []types.Type{&types.String{}}, Result: &types.Bool{}}, expected: false},
 		{t: &types.Func{Result: &types.Bool{}}, u: &types.Bool{}, expected: false},
 		{t: &types.Bool{}, u: &types.Func{Result: &types.Bool{}}, expected: false},
+
+		{t: &types.Void{}, u: &types.Void{}, expected: true},
+		{t: &types.Void{}, u: &types.Bool{}, expected: false},
+		{t: &types.Builtin{Name: "print"}, u: &types.Builtin{Name: "print"}, expected: true},
+		{t: &types.Builtin{Name: "print"}, u: &types.Builtin{Name: "println"}, expected: false},
 	}
 
 	for i, test := range tests {
@@ -0,0 +1,273 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm // import "davidrjenni.io/lang/vm"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"davidrjenni.io/lang/ir"
+)
+
+// The bytecode format starts with the magic and the version,
+// followed by the filename, the string constants and the funcs.
+// Integers are encoded as varints and strings are prefixed with
+// their lengths. An instruction consists of its opcode, its line
+// and its operands, whose first byte is the kind and the type.
+const (
+	magic   = "\x00lbc"
+	version = 1
+)
+
+// WriteTo writes the program in the bytecode format to w.
+func (p *Program) WriteTo(w io.Writer) (int64, error) {
+	e := &encoder{buf: []byte(magic)}
+	e.uvarint(version)
+	e.string(p.filename)
+	e.uvarint(uint64(len(p.strs)))
+	for _, s := range p.strs {
+		e.string(s)
+	}
+	e.uvarint(uint64(len(p.funcs)))
+	for _, fn := range p.funcs {
+		e.string(fn.name)
+		e.uvarint(uint64(fn.regs))
+		e.uvarint(uint64(len(fn.code)))
+		for _, in := range fn.code {
+			e.buf = append(e.buf, byte(in.op))
+			e.uvarint(uint64(in.line))
+			e.operand(in.a)
+			e.operand(in.b)
+		}
+	}
+	n, err := w.Write(e.buf)
+	return int64(n), err
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) operand(o operand) {
+	e.buf = append(e.buf, byte(o.kind)<<2|byte(o.typ))
+	switch o.kind {
+	case none:
+	case mem:
+		e.varint(o.val)
+		e.varint(int64(o.base))
+		e.varint(int64(o.index))
+		e.uvarint(uint64(o.scale))
+	default:
+		e.varint(o.val)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) uvarint(x uint64) { e.buf = binary.AppendUvarint(e.buf, x) }
+func (e *encoder) varint(x int64)   { e.buf = binary.AppendVarint(e.buf, x) }
+
+// Read reads a program in the bytecode format. The program
+// is validated, such that the VM can rely on its structure.
+func Read(r io.Reader) (p *Program, err error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	var m [len(magic)]byte
+	if _, err := io.ReadFull(d.r, m[:]); err != nil || string(m[:]) != magic {
+		return nil, errors.New("not a bytecode file")
+	}
+	if v := d.uvarint(); v != version {
+		return nil, fmt.Errorf("unsupported bytecode version %d", v)
+	}
+
+	// The slices are not preallocated, such that
+	// invalid lengths fail at the end of the input.
+	p = &Program{filename: d.string()}
+	for n := d.len(); len(p.strs) < n; {
+		p.strs = append(p.strs, d.string())
+	}
+	for n := d.len(); len(p.funcs) < n; {
+		fn := &function{name: d.string(), regs: d.len()}
+		for n := d.len(); len(fn.code) < n; {
+			in := instr{op: Op(d.byte()), line: d.len()}
+			in.a = d.operand()
+			in.b = d.operand()
+			fn.code = append(fn.code, in)
+		}
+		p.funcs = append(p.funcs, fn)
+	}
+
+	if len(p.funcs) == 0 {
+		d.fail("missing main func")
+	}
+	for _, fn := range p.funcs {
+		// The VM must not run past the end of a func.
+		if n := len(fn.code); n == 0 || (fn.code[n-1].op != Return && fn.code[n-1].op != Jump) {
+			d.fail("%s: missing return", fn.name)
+		}
+		for j, in := range fn.code {
+			if err := p.validate(fn, in); err != nil {
+				d.fail("%s: instruction %d: %v", fn.name, j, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// validate checks the operands of the instruction.
+func (p *Program) validate(fn *function, in instr) error {
+	for _, o := range []operand{in.a, in.b} {
+		if err := p.validateOperand(fn, o); err != nil {
+			return err
+		}
+	}
+	isReg := func(o operand) bool { return o.kind == reg }
+	isValue := func(o operand) bool { return o.kind != none && o.kind != native }
+	isNone := func(o operand) bool { return o.kind == none }
+	var ok bool
+	switch in.op {
+	case Load:
+		ok = isReg(in.a) && isValue(in.b)
+	case Store:
+		ok = in.a.kind == mem && isValue(in.b)
+	case Neg, Setl, Setle, Sete, Setne, Setg, Setge, Seta, Setae, Setp, Setnp:
+		ok = isReg(in.a) && isNone(in.b)
+	case Add, Sub, Mul, Div, And, Or, Cmp:
+		ok = isReg(in.a) && isValue(in.b)
+	case Jump, CJump:
+		ok = in.a.kind == const_ && in.a.val >= 0 && in.a.val < int64(len(fn.code)) && isNone(in.b)
+	case Call:
+		ok = isValue(in.a) && isNone(in.b)
+	case CallNative:
+		ok = in.a.kind == native && isNone(in.b)
+	case Return:
+		ok = isNone(in.a) && isNone(in.b)
+	default:
+		return fmt.Errorf("invalid opcode %d", in.op)
+	}
+	if !ok {
+		return fmt.Errorf("invalid operands of %s", in.op)
+	}
+	return nil
+}
+
+func (p *Program) validateOperand(fn *function, o operand) error {
+	if o.typ > ir.I64Reg {
+		return fmt.Errorf("invalid type %d", o.typ)
+	}
+	isReg := func(r int64) bool { return -maxArgs <= r && r <= int64(fn.regs) }
+	var ok bool
+	switch o.kind {
+	case none, const_:
+		ok = true
+	case reg:
+		ok = isReg(o.val)
+	case str:
+		ok = 0 <= o.val && o.val < int64(len(p.strs))
+	case funcRef:
+		ok = 0 <= o.val && o.val < int64(len(p.funcs))
+	case mem:
+		ok = isReg(int64(o.base)) && isReg(int64(o.index)) && (o.scale == 0 || o.scale == 1 || o.scale == 8)
+	case native:
+		ok = 0 <= o.val && o.val < int64(len(natives))
+	default:
+		return fmt.Errorf("invalid operand kind %d", o.kind)
+	}
+	if !ok {
+		return fmt.Errorf("invalid operand %+v", o)
+	}
+	return nil
+}
+
+type decodeError string
+
+func (e decodeError) Error() string { return "invalid bytecode: " + string(e) }
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	panic(decodeError(fmt.Sprintf(format, args...)))
+}
+
+func (d *decoder) operand() operand {
+	b := d.byte()
+	o := operand{kind: kind(b >> 2), typ: ir.RegType(b & 3)}
+	switch o.kind {
+	case none:
+	case mem:
+		o.val = d.varint()
+		o.base = int(d.varint())
+		o.index = int(d.varint())
+		o.scale = d.len()
+	default:
+		o.val = d.varint()
+	}
+	return o
+}
+
+func (d *decoder) string() string {
+	n := d.len()
+	b, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		d.fail("%v", err)
+	}
+	if len(b) < n {
+		d.fail("%v", io.ErrUnexpectedEOF)
+	}
+	return string(b)
+}
+
+// len reads a length, which must be reasonably small.
+func (d *decoder) len() int {
+	x := d.uvarint()
+	if x > 1<<30 {
+		d.fail("length %d too large", x)
+	}
+	return int(x)
+}
+
+func (d *decoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.fail("%v", err)
+	}
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail("%v", err)
+	}
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail("%v", err)
+	}
+	return x
+}
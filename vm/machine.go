@@ -0,0 +1,454 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm // import "davidrjenni.io/lang/vm"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"davidrjenni.io/lang/ir"
+)
+
+// Error is a violation detected at run time, e.g. a violated
+// assertion. Its message is the one of the compiled programs.
+type Error struct {
+	Filename string
+	Line     int
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Msg)
+}
+
+// maxArgs is the number of argument registers of each type.
+const maxArgs = 8
+
+// Run runs the program. The output of print and println is written
+// to out. Run stops at the first violation and returns it as *Error.
+func Run(out io.Writer, p *Program) (err error) {
+	w := bufio.NewWriter(out)
+	m := &machine{
+		prog: p,
+		out:  w,
+		heap: make([]byte, 8),
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(invalidAddr)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	for _, s := range p.strs {
+		addr := m.alloc(int64(len(s)) + 1)
+		copy(m.heap[addr:], s)
+		m.strs = append(m.strs, addr)
+	}
+	return m.run()
+}
+
+// invalidAddr is an access of memory outside of the heap.
+type invalidAddr int64
+
+func (a invalidAddr) Error() string {
+	return fmt.Sprintf("invalid memory address %#x", int64(a))
+}
+
+type machine struct {
+	prog *Program
+	out  *bufio.Writer
+
+	heap []byte  // memory, whose address 0 is never allocated
+	strs []int64 // addresses of the string constants
+
+	// fixed contains the result and the argument registers,
+	// indexed by their number, of the i64 and bool values
+	// and of the f64 values.
+	fixed [2][maxArgs + 1]uint64
+
+	flags  flags
+	frames []*frame // call stack
+}
+
+// frame is the activation of a func.
+type frame struct {
+	fn   *function
+	pc   int
+	regs []uint64 // virtual registers, indexed by their number
+}
+
+// flags are the results of the last comparison of RHS and LHS.
+// Unordered f64 values, i.e. NaN, set zf, cf and pf, like on x86.
+type flags struct {
+	zf bool // RHS = LHS
+	cf bool // RHS < LHS, unsigned for i64 values
+	pf bool // RHS and LHS are unordered
+	lt bool // RHS < LHS, signed
+}
+
+func (m *machine) run() error {
+	m.call(0)
+	for len(m.frames) > 0 {
+		fr := m.frames[len(m.frames)-1]
+		in := &fr.fn.code[fr.pc]
+		fr.pc++
+
+		switch in.op {
+		case Load:
+			m.set(fr, in.a, m.value(fr, in.b))
+		case Store:
+			m.store(m.addr(fr, in.a), in.a.typ, m.value(fr, in.b))
+		case Neg:
+			x := m.value(fr, in.a)
+			if in.a.typ == ir.F64Reg {
+				m.set(fr, in.a, x^(1<<63))
+			} else {
+				m.set(fr, in.a, uint64(-int64(x)))
+			}
+		case Add, Sub, Mul, Div, And, Or:
+			x, err := m.arith(in, m.value(fr, in.a), m.value(fr, in.b))
+			if err != nil {
+				return err
+			}
+			m.set(fr, in.a, x)
+		case Cmp:
+			m.flags = compare(in.a.typ, m.value(fr, in.a), m.value(fr, in.b))
+		case Setl, Setle, Sete, Setne, Setg, Setge, Seta, Setae, Setp, Setnp:
+			var x uint64
+			if m.flags.test(in.op) {
+				x = 1
+			}
+			m.set(fr, in.a, x)
+		case Jump:
+			fr.pc = int(in.a.val)
+		case CJump:
+			if m.flags.zf {
+				fr.pc = int(in.a.val)
+			}
+		case Call:
+			// The addresses of funcs are negative, such
+			// that they differ from the addresses on the heap.
+			i := -int64(m.value(fr, in.a)) - 1
+			if i < 0 || i >= int64(len(m.prog.funcs)) {
+				return fmt.Errorf("invalid func address %#x", -i-1)
+			}
+			m.call(int(i))
+		case CallNative:
+			if err := natives[in.a.val].fn(m); err != nil {
+				return err
+			}
+		case Return:
+			m.frames = m.frames[:len(m.frames)-1]
+		default:
+			panic(fmt.Sprintf("unexpected op %s", in.op))
+		}
+	}
+	return nil
+}
+
+func (m *machine) call(i int) {
+	fn := m.prog.funcs[i]
+	m.frames = append(m.frames, &frame{fn: fn, regs: make([]uint64, fn.regs+1)})
+}
+
+// arith applies the arithmetic or logical op to x and y.
+func (m *machine) arith(in *instr, x, y uint64) (uint64, error) {
+	if in.a.typ == ir.F64Reg {
+		f, g := math.Float64frombits(x), math.Float64frombits(y)
+		switch in.op {
+		case Add:
+			return math.Float64bits(f + g), nil
+		case Sub:
+			return math.Float64bits(f - g), nil
+		case Mul:
+			return math.Float64bits(f * g), nil
+		case Div:
+			return math.Float64bits(f / g), nil
+		}
+		panic(fmt.Sprintf("unexpected f64 reg for op %s", in.op))
+	}
+	switch in.op {
+	case Add:
+		return x + y, nil
+	case Sub:
+		return x - y, nil
+	case Mul:
+		return uint64(int64(x) * int64(y)), nil
+	case Div:
+		if y == 0 {
+			return 0, &Error{Filename: m.prog.filename, Line: in.line, Msg: "division by zero"}
+		}
+		return uint64(int64(x) / int64(y)), nil
+	case And:
+		return x & y, nil
+	case Or:
+		return x | y, nil
+	}
+	panic(fmt.Sprintf("unexpected op %s", in.op))
+}
+
+// compare compares x and y of the given type.
+func compare(typ ir.RegType, x, y uint64) flags {
+	switch typ {
+	case ir.BoolReg:
+		return flags{zf: x == y, cf: x < y, lt: int8(x) < int8(y)}
+	case ir.F64Reg:
+		f, g := math.Float64frombits(x), math.Float64frombits(y)
+		if math.IsNaN(f) || math.IsNaN(g) {
+			return flags{zf: true, cf: true, pf: true}
+		}
+		return flags{zf: f == g, cf: f < g, lt: f < g}
+	default:
+		return flags{zf: x == y, cf: x < y, lt: int64(x) < int64(y)}
+	}
+}
+
+// test reports whether the set op sets its register.
+func (f flags) test(op Op) bool {
+	switch op {
+	case Setl:
+		return f.lt
+	case Setle:
+		return f.lt || f.zf
+	case Sete:
+		return f.zf
+	case Setne:
+		return !f.zf
+	case Setg:
+		return !f.lt && !f.zf
+	case Setge:
+		return !f.lt
+	case Seta:
+		return !f.cf && !f.zf
+	case Setae:
+		return !f.cf
+	case Setp:
+		return f.pf
+	case Setnp:
+		return !f.pf
+	default:
+		panic(fmt.Sprintf("unexpected op %s", op))
+	}
+}
+
+// value returns the value of the operand. Bool
+// values are only stored in the lowest byte.
+func (m *machine) value(fr *frame, o operand) uint64 {
+	switch o.kind {
+	case reg:
+		x := *m.reg(fr, o.val, o.typ)
+		if o.typ == ir.BoolReg {
+			x &= 0xff
+		}
+		return x
+	case const_:
+		return uint64(o.val)
+	case str:
+		return uint64(m.strs[o.val])
+	case funcRef:
+		return uint64(-o.val - 1)
+	case mem:
+		return m.load(m.addr(fr, o), o.typ)
+	default:
+		panic(fmt.Sprintf("unexpected operand kind %d", o.kind))
+	}
+}
+
+// set sets the register of the operand to the value.
+func (m *machine) set(fr *frame, o operand, x uint64) {
+	*m.reg(fr, o.val, o.typ) = x
+}
+
+func (m *machine) reg(fr *frame, r int64, typ ir.RegType) *uint64 {
+	if r > 0 {
+		return &fr.regs[r]
+	}
+	if typ == ir.F64Reg {
+		return &m.fixed[1][-r]
+	}
+	return &m.fixed[0][-r]
+}
+
+// addr returns the address of the memory operand.
+func (m *machine) addr(fr *frame, o operand) int64 {
+	a := int64(*m.reg(fr, int64(o.base), ir.I64Reg)) + o.val
+	if o.scale != 0 {
+		a += int64(*m.reg(fr, int64(o.index), ir.I64Reg)) * int64(o.scale)
+	}
+	return a
+}
+
+// alloc allocates n bytes, aligned to 8 bytes,
+// on the heap and returns their address.
+func (m *machine) alloc(n int64) int64 {
+	if n < 0 || n > math.MaxInt32 {
+		panic(invalidAddr(n))
+	}
+	addr := int64(len(m.heap)+7) &^ 7
+	m.heap = append(m.heap, make([]byte, addr-int64(len(m.heap))+n)...)
+	return addr
+}
+
+func (m *machine) load(addr int64, typ ir.RegType) uint64 {
+	b := m.mem(addr, size(typ))
+	if typ == ir.BoolReg {
+		return uint64(b[0])
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (m *machine) store(addr int64, typ ir.RegType, x uint64) {
+	b := m.mem(addr, size(typ))
+	if typ == ir.BoolReg {
+		b[0] = byte(x)
+		return
+	}
+	binary.LittleEndian.PutUint64(b, x)
+}
+
+// mem returns the n bytes of the heap at the address.
+func (m *machine) mem(addr int64, n int) []byte {
+	if addr < 8 || addr > int64(len(m.heap)-n) {
+		panic(invalidAddr(addr))
+	}
+	return m.heap[addr : addr+int64(n)]
+}
+
+// cstring returns the NUL-terminated string at the address.
+func (m *machine) cstring(addr int64) []byte {
+	m.mem(addr, 1)
+	n := bytes.IndexByte(m.heap[addr:], 0)
+	if n < 0 {
+		panic(invalidAddr(addr))
+	}
+	return m.heap[addr : addr+int64(n)]
+}
+
+func size(typ ir.RegType) int {
+	if typ == ir.BoolReg {
+		return 1
+	}
+	return 8
+}
+
+// natives are the macros and the runtime funcs of the compiled
+// programs. Their indices are part of the bytecode format, hence
+// new ones must be appended.
+var natives = [...]struct {
+	name string
+	fn   func(m *machine) error
+}{
+	{name: "AssertViolated", fn: func(m *machine) error { return m.violated("assertion violated") }},
+	{name: "BoundsViolated", fn: func(m *machine) error { return m.violated("index out of bounds") }},
+	{name: "malloc", fn: func(m *machine) error {
+		m.result(uint64(m.alloc(m.arg(1))))
+		return nil
+	}},
+	{name: "calloc", fn: func(m *machine) error {
+		m.result(uint64(m.alloc(m.arg(1) * m.arg(2))))
+		return nil
+	}},
+	{name: "lang_concat", fn: func(m *machine) error {
+		a, b := m.cstring(m.arg(1)), m.cstring(m.arg(2))
+		s := m.alloc(int64(len(a) + len(b) + 1))
+		copy(m.heap[s+int64(copy(m.heap[s:], a)):], b)
+		m.result(uint64(s))
+		return nil
+	}},
+	{name: "lang_strcmp", fn: func(m *machine) error {
+		m.result(uint64(bytes.Compare(m.cstring(m.arg(1)), m.cstring(m.arg(2)))))
+		return nil
+	}},
+	{name: "lang_substring", fn: func(m *machine) error {
+		m.resultBool(bytes.Contains(m.cstring(m.arg(2)), m.cstring(m.arg(1))))
+		return nil
+	}},
+	{name: "lang_elem_bool", fn: func(m *machine) error {
+		x := byte(m.arg(1))
+		m.resultBool(m.elem(m.arg(2), ir.BoolReg, func(y uint64) bool { return byte(y) == x }))
+		return nil
+	}},
+	{name: "lang_elem_f64", fn: func(m *machine) error {
+		x := math.Float64frombits(m.fixed[1][1])
+		m.resultBool(m.elem(m.arg(1), ir.F64Reg, func(y uint64) bool { return math.Float64frombits(y) == x }))
+		return nil
+	}},
+	{name: "lang_elem_i64", fn: func(m *machine) error {
+		x := uint64(m.arg(1))
+		m.resultBool(m.elem(m.arg(2), ir.I64Reg, func(y uint64) bool { return y == x }))
+		return nil
+	}},
+	{name: "lang_elem_string", fn: func(m *machine) error {
+		s := m.cstring(m.arg(1))
+		m.resultBool(m.elem(m.arg(2), ir.I64Reg, func(y uint64) bool { return bytes.Equal(m.cstring(int64(y)), s) }))
+		return nil
+	}},
+	{name: "lang_print_bool", fn: func(m *machine) error {
+		m.out.WriteString(strconv.FormatBool(byte(m.arg(1)) != 0))
+		return nil
+	}},
+	{name: "lang_print_f64", fn: func(m *machine) error {
+		// Like the runtime, with the fewest digits, which
+		// represent the f64 exactly.
+		m.out.WriteString(strconv.FormatFloat(math.Float64frombits(m.fixed[1][1]), 'g', -1, 64))
+		return nil
+	}},
+	{name: "lang_print_i64", fn: func(m *machine) error {
+		m.out.WriteString(strconv.FormatInt(m.arg(1), 10))
+		return nil
+	}},
+	{name: "lang_print_string", fn: func(m *machine) error {
+		m.out.Write(m.cstring(m.arg(1)))
+		return nil
+	}},
+	{name: "DivisionByZero", fn: func(m *machine) error { return m.violated("division by zero") }},
+}
+
+// violated returns the violation with the line
+// passed in the third argument register.
+func (m *machine) violated(msg string) error {
+	return &Error{Filename: m.prog.filename, Line: int(m.arg(3)), Msg: msg}
+}
+
+// arg returns the i64 argument register i.
+func (m *machine) arg(i int) int64 {
+	return int64(m.fixed[0][i])
+}
+
+func (m *machine) result(x uint64) {
+	m.fixed[0][0] = x
+}
+
+func (m *machine) resultBool(b bool) {
+	if b {
+		m.result(1)
+	} else {
+		m.result(0)
+	}
+}
+
+// elem reports whether one of the elems of the given type
+// of the array at the address satisfies eq.
+func (m *machine) elem(arr int64, typ ir.RegType, eq func(uint64) bool) bool {
+	n := int64(m.load(arr, ir.I64Reg))
+	for i := int64(0); i < n; i++ {
+		if eq(m.load(arr+8+i*int64(size(typ)), typ)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm // import "davidrjenni.io/lang/vm"
+
+//go:generate stringer -type=Op -linecomment
+
+// Op is the opcode of an instruction. The opcodes are part
+// of the bytecode format, hence new ones must be appended.
+type Op byte
+
+const (
+	Load  Op = iota // load
+	Store           // store
+
+	Neg // neg
+	Add // add
+	Sub // sub
+	Mul // mul
+	Div // div
+	And // and
+	Or  // or
+	Cmp // cmp
+
+	Setl  // setl
+	Setle // setle
+	Sete  // sete
+	Setne // setne
+	Setg  // setg
+	Setge // setge
+	Seta  // seta
+	Setae // setae
+	Setp  // setp
+	Setnp // setnp
+
+	Jump       // jump
+	CJump      // cjump
+	Call       // call
+	CallNative // callnative
+	Return     // return
+)
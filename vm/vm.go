@@ -0,0 +1,270 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm // import "davidrjenni.io/lang/vm"
+
+import (
+	"fmt"
+	"math"
+
+	"davidrjenni.io/lang/ir"
+)
+
+// Program is a program in bytecode, which is run by the VM. The
+// bytecode is a portable form of the ir: the instructions operate
+// on the registers of the ir and on a byte-addressed heap, such
+// that the values have the same layout as in the compiled programs.
+type Program struct {
+	filename string   // filename reported by violations
+	strs     []string // string constants
+	funcs    []*function
+}
+
+// function is a func of the program. The first
+// function of a program is the main block.
+type function struct {
+	name string
+	regs int // number of virtual registers
+	code []instr
+}
+
+// instr is an instruction. The first operand is the destination
+// and the second one the source. Jumps jump to the instruction,
+// whose index is the constant in the first operand.
+type instr struct {
+	op   Op
+	line int // line in the source code, or 0
+	a, b operand
+}
+
+type kind byte
+
+const (
+	none    kind = iota
+	reg          // register val
+	const_       // constant val, f64 constants are their bits
+	str          // address of the string constant val
+	funcRef      // address of the func val
+	mem          // memory at the address base+index*scale+val
+	native       // runtime func val
+)
+
+// operand is an operand of an instruction. The registers are numbered
+// like in the ir: virtual registers from 1 upwards, argument registers
+// from -1 downwards and 0 is the register holding the results.
+type operand struct {
+	kind  kind
+	typ   ir.RegType // type of the value, which determines the size in memory
+	val   int64
+	base  int // register holding the base address
+	index int // register holding the index
+	scale int // scale of the index, or 0, if there is no index
+}
+
+// Compile compiles the frames of a program into bytecode.
+// The filename is reported by violated assertions.
+func Compile(filename string, frames []*ir.Frame) *Program {
+	c := &compiler{
+		prog:  &Program{filename: filename},
+		funcs: make(map[ir.Label]int),
+		strs:  make(map[string]int),
+	}
+	for i, f := range frames {
+		c.funcs[f.Name] = i
+	}
+	for _, f := range frames {
+		c.prog.funcs = append(c.prog.funcs, c.compileFrame(f))
+	}
+	return c.prog
+}
+
+type compiler struct {
+	prog  *Program
+	funcs map[ir.Label]int // indices of the funcs
+	strs  map[string]int   // indices of the string constants
+	regs  int              // number of virtual registers of the current frame
+}
+
+func (c *compiler) compileFrame(f *ir.Frame) *function {
+	c.regs = 0
+	fn := &function{name: string(f.Name)}
+
+	// The targets of the jumps are resolved,
+	// after the labels are known.
+	labels := make(map[ir.Label]int)
+	jumps := make(map[int]ir.Label)
+	for _, n := range f.Seq {
+		switch n := n.(type) {
+		case ir.Label:
+			labels[n] = len(fn.code)
+		case *ir.CJump:
+			jumps[len(fn.code)] = n.Label
+			fn.code = append(fn.code, instr{op: CJump, line: n.Pos().Line()})
+		case *ir.Jump:
+			jumps[len(fn.code)] = n.Label
+			fn.code = append(fn.code, instr{op: Jump, line: n.Pos().Line()})
+		default:
+			fn.code = append(fn.code, c.compile(n)...)
+		}
+	}
+	for i, l := range jumps {
+		target, ok := labels[l]
+		if !ok {
+			panic(fmt.Sprintf("undefined label %s", l))
+		}
+		fn.code[i].a = operand{kind: const_, typ: ir.I64Reg, val: int64(target)}
+	}
+
+	// Like in the compiled programs, the
+	// frames return 0 at their end.
+	fn.code = append(fn.code,
+		instr{op: Load, a: c.reg(&ir.Reg{Type: ir.I64Reg}), b: c.rval(ir.I64(0), ir.I64Reg)},
+		instr{op: Return},
+	)
+	fn.regs = c.regs
+	return fn
+}
+
+func (c *compiler) compile(n ir.Node) []instr {
+	switch n := n.(type) {
+	case *ir.BinaryInstr:
+		var code []instr
+		if n.Src != nil && n.Op != ir.Cmp {
+			code = append(code, instr{op: Load, line: n.Pos().Line(), a: c.reg(n.RHS), b: c.reg(n.Src)})
+		}
+		op, ok := binOps[n.Op]
+		if !ok {
+			panic(fmt.Sprintf("unexpected op %s", n.Op))
+		}
+		return append(code, instr{op: op, line: n.Pos().Line(), a: c.reg(n.RHS), b: c.rval(n.LHS, n.RHS.Type)})
+	case *ir.Call:
+		in := instr{op: Call, line: n.Pos().Line()}
+		switch f := n.Func.(type) {
+		case nil:
+			in.op, in.a = CallNative, c.native(n.Label)
+		case ir.Label:
+			if _, ok := c.funcs[f]; !ok {
+				in.op, in.a = CallNative, c.native(f)
+				break
+			}
+			in.a = c.rval(f, ir.I64Reg)
+		default:
+			in.a = c.rval(f, ir.I64Reg)
+		}
+		return []instr{in}
+	case *ir.Load:
+		return []instr{{op: Load, line: n.Pos().Line(), a: c.reg(n.Dst), b: c.rval(n.Src, n.Dst.Type)}}
+	case *ir.Return:
+		return []instr{{op: Return, line: n.Pos().Line()}}
+	case *ir.Store:
+		return []instr{{op: Store, line: n.Pos().Line(), a: c.rval(n.Dst, n.Size), b: c.rval(n.Src, n.Size)}}
+	case *ir.UnaryInstr:
+		var code []instr
+		if n.Src != nil && n.Op == ir.Neg {
+			code = append(code, instr{op: Load, line: n.Pos().Line(), a: c.reg(n.Reg), b: c.reg(n.Src)})
+		}
+		op, ok := unaryOps[n.Op]
+		if !ok {
+			panic(fmt.Sprintf("unexpected op %s", n.Op))
+		}
+		return append(code, instr{op: op, line: n.Pos().Line(), a: c.reg(n.Reg)})
+	default:
+		panic(fmt.Sprintf("unexpected type %T", n))
+	}
+}
+
+// rval returns the operand of the value. Values in
+// memory are of the given type.
+func (c *compiler) rval(v ir.RVal, typ ir.RegType) operand {
+	switch v := v.(type) {
+	case ir.Bool:
+		o := operand{kind: const_, typ: ir.BoolReg}
+		if v {
+			o.val = 1
+		}
+		return o
+	case ir.F64:
+		return operand{kind: const_, typ: ir.F64Reg, val: int64(math.Float64bits(float64(v)))}
+	case ir.I64:
+		return operand{kind: const_, typ: ir.I64Reg, val: int64(v)}
+	case ir.Label:
+		i, ok := c.funcs[v]
+		if !ok {
+			panic(fmt.Sprintf("undefined func %s", v))
+		}
+		return operand{kind: funcRef, typ: ir.I64Reg, val: int64(i)}
+	case ir.String:
+		i, ok := c.strs[string(v)]
+		if !ok {
+			i = len(c.prog.strs)
+			c.strs[string(v)] = i
+			c.prog.strs = append(c.prog.strs, string(v))
+		}
+		return operand{kind: str, typ: ir.I64Reg, val: int64(i)}
+	case *ir.Mem:
+		if v.Base == nil {
+			panic("unexpected memory relative to the frame pointer")
+		}
+		o := operand{kind: mem, typ: typ, val: int64(v.Off), base: int(c.reg(v.Base).val)}
+		if v.Index != nil {
+			o.index, o.scale = int(c.reg(v.Index).val), v.Scale
+		}
+		return o
+	case *ir.Reg:
+		return c.reg(v)
+	default:
+		panic(fmt.Sprintf("unexpected type %T", v))
+	}
+}
+
+// reg returns the operand of the register.
+func (c *compiler) reg(r *ir.Reg) operand {
+	o := operand{kind: reg, typ: r.Type}
+	switch {
+	case r.Virt > 0:
+		o.val = int64(r.Virt)
+		if r.Virt > c.regs {
+			c.regs = r.Virt
+		}
+	case r.Arg > 0:
+		o.val = int64(-r.Arg)
+	}
+	return o
+}
+
+// native returns the operand of the runtime func or macro.
+func (c *compiler) native(l ir.Label) operand {
+	for i, n := range natives {
+		if n.name == string(l) {
+			return operand{kind: native, val: int64(i)}
+		}
+	}
+	panic(fmt.Sprintf("undefined runtime func %s", l))
+}
+
+var (
+	binOps = map[ir.Op]Op{
+		ir.Add: Add,
+		ir.Sub: Sub,
+		ir.Mul: Mul,
+		ir.Div: Div,
+		ir.And: And,
+		ir.Or:  Or,
+		ir.Cmp: Cmp,
+	}
+
+	unaryOps = map[ir.Op]Op{
+		ir.Neg:   Neg,
+		ir.Setl:  Setl,
+		ir.Setle: Setle,
+		ir.Sete:  Sete,
+		ir.Setne: Setne,
+		ir.Setg:  Setg,
+		ir.Setge: Setge,
+		ir.Seta:  Seta,
+		ir.Setae: Setae,
+		ir.Setp:  Setp,
+		ir.Setnp: Setnp,
+	}
+)
@@ -0,0 +1,152 @@
+// Copyright (c) 2023 David Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidrjenni.io/lang/ast"
+	"davidrjenni.io/lang/internal/errors"
+	"davidrjenni.io/lang/ir"
+	"davidrjenni.io/lang/parser"
+	"davidrjenni.io/lang/types"
+	"davidrjenni.io/lang/vm"
+)
+
+func TestRun(t *testing.T) {
+	// The VM must print the same output as the interpreter.
+	filename := filepath.Join("..", "interp", "test-fixtures", "input.l")
+	b, _, err := parser.ParseFile(filename)
+	if err != nil {
+		t.Fatalf("cannot parse file: %v", err)
+	}
+	p := compile(t, filename, b)
+
+	expected, err := ioutil.ReadFile(filepath.Join("..", "interp", "test-fixtures", "input.golden"))
+	if err != nil {
+		t.Fatalf("cannot read golden file: %v", err)
+	}
+
+	var actual bytes.Buffer
+	if err := vm.Run(&actual, p); err != nil {
+		t.Fatalf("cannot run: %v", err)
+	}
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Fatalf("expected\n%s\ngot\n%s\n", string(expected), actual.String())
+	}
+
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		t.Fatalf("cannot write program: %v", err)
+	}
+	p, err = vm.Read(&buf)
+	if err != nil {
+		t.Fatalf("cannot read program: %v", err)
+	}
+
+	actual.Reset()
+	if err := vm.Run(&actual, p); err != nil {
+		t.Fatalf("cannot run read program: %v", err)
+	}
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Fatalf("read program: expected\n%s\ngot\n%s\n", string(expected), actual.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := [...]struct {
+		src, out, err string
+	}{
+		{
+			src: "{\n\tlet a := []i64{1};\n\tprint(a[0]);\n\tassert a[0] > 2;\n\tprint(2);\n}",
+			out: "1",
+			err: "a.l:4: assertion violated",
+		},
+		{
+			src: "{\n\tlet a := [2]i64{};\n\tlet i := 2;\n\tprintln(a[i - 3]);\n}",
+			err: "a.l:4: index out of bounds",
+		},
+		{
+			src: "{\n\tlet a := []string{\"a\"};\n\tset a[1] <- \"b\";\n}",
+			err: "a.l:3: index out of bounds",
+		},
+		{
+			src: "{\n\tlet f := func(n i64) i64 {\n\t\treturn 1 / n;\n\t};\n\tprintln(f(1), f(0));\n}",
+			err: "a.l:3: division by zero",
+		},
+	}
+
+	for _, test := range tests {
+		b, _, err := parser.Parse(strings.NewReader(test.src), "a.l")
+		if err != nil {
+			t.Fatalf("cannot parse %q: %v", test.src, err)
+		}
+		p := compile(t, "a.l", b)
+
+		var out bytes.Buffer
+		err = vm.Run(&out, p)
+		if _, ok := err.(*vm.Error); !ok || err.Error() != test.err {
+			t.Errorf("%q: expected error %q, got %v", test.src, test.err, err)
+		}
+		if out.String() != test.out {
+			t.Errorf("%q: expected output %q, got %q", test.src, test.out, out.String())
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	b, _, err := parser.Parse(strings.NewReader("{ println(\"a\"); }"), "a.l")
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := compile(t, "a.l", b).WriteTo(&buf); err != nil {
+		t.Fatalf("cannot write program: %v", err)
+	}
+	valid := buf.Bytes()
+
+	tests := [...]struct {
+		name  string
+		input []byte
+	}{
+		{name: "empty", input: nil},
+		{name: "magic", input: []byte("\x00lbd\x01")},
+		{name: "version", input: append([]byte("\x00lbc\x02"), valid[5:]...)},
+		{name: "truncated", input: valid[:len(valid)-1]},
+		{name: "opcode", input: replaceLast(valid, byte(vm.Return), 0xff)},
+	}
+
+	for _, test := range tests {
+		if _, err := vm.Read(bytes.NewReader(test.input)); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func compile(t *testing.T, filename string, b *ast.Block) *vm.Program {
+	t.Helper()
+	info, err := types.Check(b)
+	if err != nil {
+		t.Fatalf("cannot check %s: %v", filename, err)
+	}
+	var errs errors.Errors
+	frames := ir.Translate(b, info, ir.Fold(&errs), ir.Unreachable, ir.Loads)
+	if err := errs.Err(); err != nil {
+		t.Fatalf("cannot translate %s: %v", filename, err)
+	}
+	return vm.Compile(filename, frames)
+}
+
+// replaceLast replaces the last occurrence of the byte old.
+func replaceLast(b []byte, old, new byte) []byte {
+	i := bytes.LastIndexByte(b, old)
+	r := append([]byte(nil), b...)
+	r[i] = new
+	return r
+}